@@ -0,0 +1,690 @@
+package admin
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"time"
+
+	auth "../authenticate-request"
+	remove "../delete-exercise"
+	archive "../exercise-archive"
+	history "../exercise-history"
+	plausibility "../exercise-plausibility"
+	records "../exercise-records"
+	rank "../get-ranking"
+	"github.com/gorilla/mux"
+)
+
+var (
+	// ErrInvalidType Error when type is not an uppercase identifier
+	ErrInvalidType = errors.New("Invalid type must only contain uppercase letters and underscores")
+	// ErrInvalidMultiplicationFactor Error when multiplicationFactor field is not positive
+	ErrInvalidMultiplicationFactor = errors.New("Invalid multiplicationFactor must be a positive number")
+	// ErrInvalidDistanceFactor Error when distanceFactor field is negative
+	ErrInvalidDistanceFactor = errors.New("Invalid distanceFactor must not be negative")
+	// ErrInvalidLoadFactor Error when loadFactor field is negative
+	ErrInvalidLoadFactor = errors.New("Invalid loadFactor must not be negative")
+	// ErrInvalidEstimatedCaloriesFactor Error when estimatedCaloriesFactor field is not between 0 and 1
+	ErrInvalidEstimatedCaloriesFactor = errors.New("Invalid estimatedCaloriesFactor must be between 0 and 1")
+	// ErrInvalidPlausibilityRule Error when maxCaloriesPerMinute or maxDuration fields are negative
+	ErrInvalidPlausibilityRule = errors.New("Invalid maxCaloriesPerMinute and maxDuration must not be negative")
+	// ErrInvalidMET Error when lowMet, moderateMet or highMet fields are out of range
+	ErrInvalidMET = errors.New("Invalid lowMet, moderateMet and highMet must be between 0 and 25")
+	// ErrInvalidPlausibilityMode Error when plausibilityMode field is invalid
+	ErrInvalidPlausibilityMode = errors.New("Invalid plausibilityMode must be REJECT or FLAG")
+	// ErrNoTypeFound The exercise type does not exists
+	ErrNoTypeFound = errors.New("The exercise type does not exists")
+	// ErrTypeInUse The exercise type still has exercises
+	ErrTypeInUse = errors.New("The exercise type can not be deleted while exercises use it")
+	// ErrInvalidUserID Error when user id is not valid
+	ErrInvalidUserID = errors.New("Invalid user id")
+	// ErrSameUser Error when merging a user into itself
+	ErrSameUser = errors.New("Can not merge a user into itself")
+	// ErrNoUserFound The user does not exists
+	ErrNoUserFound = errors.New("The user does not exists")
+	// ErrMergeOverlapping Error when exercises of the users to merge overlap each other
+	ErrMergeOverlapping = errors.New("Can not merge users with overlapping exercises")
+	// ErrInvalidLimit Error when limit param is not a positive number
+	ErrInvalidLimit = errors.New("Invalid param limit must be a positive number")
+)
+
+const (
+	defaultAuditLimit = 100
+	// defaultEstimatedCaloriesFactor share of the estimated calories ranked when the factor is not received
+	defaultEstimatedCaloriesFactor = 0.5
+	// maxMET above the most intense activity of the Compendium of Physical Activities
+	maxMET = 25.0
+)
+
+// ExerciseType exercise type and the factors used to rank it
+type ExerciseType struct {
+	Type                    string            `json:"type"`
+	MultiplicationFactor    int               `json:"multiplicationFactor"`
+	DistanceFactor          float64           `json:"distanceFactor"`
+	LoadFactor              float64           `json:"loadFactor"`
+	EstimatedCaloriesFactor float64           `json:"estimatedCaloriesFactor"`
+	MaxCaloriesPerMinute    float64           `json:"maxCaloriesPerMinute"`
+	MaxDuration             int64             `json:"maxDuration"`
+	PlausibilityMode        plausibility.Mode `json:"plausibilityMode"`
+	// LowMET, ModerateMET and HighMET metabolic equivalents calories are estimated with, zero when they can not be
+	LowMET      float64 `json:"lowMet"`
+	ModerateMET float64 `json:"moderateMet"`
+	HighMET     float64 `json:"highMet"`
+}
+
+// Merge Request structure to merge a duplicated user into another
+type Merge struct {
+	SourceUserID int64 `json:"sourceUserId"`
+	TargetUserID int64 `json:"targetUserId"`
+}
+
+// AuditEntry operation performed through the admin api
+type AuditEntry struct {
+	ID        int64           `json:"id"`
+	Actor     string          `json:"actor"`
+	Action    string          `json:"action"`
+	Target    string          `json:"target"`
+	Details   json.RawMessage `json:"details,omitempty"`
+	CreatedAt time.Time       `json:"createdAt"`
+}
+
+// Response for /admin
+type Response struct {
+	ExerciseType  *ExerciseType   `json:"exerciseType,omitempty"`
+	ExerciseTypes []*ExerciseType `json:"exerciseTypes,omitempty"`
+	Ranking       []*rank.User    `json:"ranking,omitempty"`
+	Merge         *Merge          `json:"merge,omitempty"`
+	Deleted       *int64          `json:"deleted,omitempty"`
+	Audit         []*AuditEntry   `json:"audit,omitempty"`
+	Error         string          `json:"error,omitempty"`
+}
+
+func isValidTypeName(exerciseType string) bool {
+	TypeNameRegex := regexp.MustCompile(`^[A-Z_]+$`)
+
+	return TypeNameRegex.MatchString(exerciseType)
+}
+
+func openDatabase() (*sql.DB, error) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+
+	return sql.Open("sqlite3", fmt.Sprintf("%s/egym.db", dir))
+}
+
+// recordAudit in the transaction of the operation, so that neither is saved without the other
+func recordAudit(tx *sql.Tx, actor string, action string, target string, details interface{}) error {
+	encodedDetails, err := json.Marshal(details)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`INSERT INTO admin_audit (ACTOR, ACTION, TARGET, DETAILS, CREATED_AT) VALUES ($1, $2, $3, $4, $5)`, actor, action, target, string(encodedDetails), time.Now().UTC())
+
+	return err
+}
+
+func getExerciseTypes() ([]*ExerciseType, error) {
+	database, err := openDatabase()
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := database.Query(`SELECT TYPE, MULTIPLICATION_FACTOR, DISTANCE_FACTOR, LOAD_FACTOR, ESTIMATED_CALORIES_FACTOR, MAX_CALORIES_PER_MINUTE, MAX_DURATION, PLAUSIBILITY_MODE, COALESCE(LOW_MET, 0), COALESCE(MODERATE_MET, 0), COALESCE(HIGH_MET, 0) FROM exercise_types ORDER BY TYPE`)
+	if err != nil {
+		return nil, err
+	}
+	defer result.Close()
+
+	exerciseTypes := []*ExerciseType{}
+	for result.Next() {
+		exerciseType := &ExerciseType{}
+		if err := result.Scan(&exerciseType.Type, &exerciseType.MultiplicationFactor, &exerciseType.DistanceFactor, &exerciseType.LoadFactor, &exerciseType.EstimatedCaloriesFactor, &exerciseType.MaxCaloriesPerMinute, &exerciseType.MaxDuration, &exerciseType.PlausibilityMode, &exerciseType.LowMET, &exerciseType.ModerateMET, &exerciseType.HighMET); err != nil {
+			return nil, err
+		}
+
+		exerciseTypes = append(exerciseTypes, exerciseType)
+	}
+
+	return exerciseTypes, result.Err()
+}
+
+// getExerciseType the stored factors of a type, the default ones when the type does not exist yet
+func getExerciseType(exerciseType string) (*ExerciseType, error) {
+	database, err := openDatabase()
+	if err != nil {
+		return nil, err
+	}
+
+	stored := &ExerciseType{Type: exerciseType}
+	err = database.QueryRow(`SELECT MULTIPLICATION_FACTOR, DISTANCE_FACTOR, LOAD_FACTOR, ESTIMATED_CALORIES_FACTOR, MAX_CALORIES_PER_MINUTE, MAX_DURATION, PLAUSIBILITY_MODE, COALESCE(LOW_MET, 0), COALESCE(MODERATE_MET, 0), COALESCE(HIGH_MET, 0) FROM exercise_types WHERE TYPE=$1`, exerciseType).Scan(&stored.MultiplicationFactor, &stored.DistanceFactor, &stored.LoadFactor, &stored.EstimatedCaloriesFactor, &stored.MaxCaloriesPerMinute, &stored.MaxDuration, &stored.PlausibilityMode, &stored.LowMET, &stored.ModerateMET, &stored.HighMET)
+	if err == sql.ErrNoRows {
+		return &ExerciseType{
+			Type:                    exerciseType,
+			EstimatedCaloriesFactor: defaultEstimatedCaloriesFactor,
+			MaxCaloriesPerMinute:    plausibility.DefaultMaxCaloriesPerMinute,
+			MaxDuration:             plausibility.DefaultMaxDuration,
+			PlausibilityMode:        plausibility.DefaultMode,
+		}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return stored, nil
+}
+
+func (t *ExerciseType) saveExerciseType(actor string) error {
+	database, err := openDatabase()
+	if err != nil {
+		return err
+	}
+
+	tx, err := database.Begin()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`INSERT OR REPLACE INTO exercise_types (TYPE, MULTIPLICATION_FACTOR, DISTANCE_FACTOR, LOAD_FACTOR, ESTIMATED_CALORIES_FACTOR, MAX_CALORIES_PER_MINUTE, MAX_DURATION, PLAUSIBILITY_MODE, LOW_MET, MODERATE_MET, HIGH_MET) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`, t.Type, t.MultiplicationFactor, t.DistanceFactor, t.LoadFactor, t.EstimatedCaloriesFactor, t.MaxCaloriesPerMinute, t.MaxDuration, t.PlausibilityMode, t.LowMET, t.ModerateMET, t.HighMET)
+	if err == nil {
+		err = recordAudit(tx, actor, "SAVE_EXERCISE_TYPE", t.Type, t)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func deleteExerciseType(exerciseType string, actor string) error {
+	database, err := openDatabase()
+	if err != nil {
+		return err
+	}
+
+	tx, err := database.Begin()
+	if err != nil {
+		return err
+	}
+
+	var totalExercises int
+	err = tx.QueryRow(`SELECT COUNT(*) FROM exercises WHERE TYPE=$1`, exerciseType).Scan(&totalExercises)
+	if err == nil && totalExercises > 0 {
+		err = ErrTypeInUse
+	}
+
+	var result sql.Result
+	if err == nil {
+		result, err = tx.Exec(`DELETE FROM exercise_types WHERE TYPE=$1`, exerciseType)
+	}
+
+	var deleted int64
+	if err == nil {
+		deleted, err = result.RowsAffected()
+	}
+	if err == nil && deleted == 0 {
+		err = ErrNoTypeFound
+	}
+	if err == nil {
+		err = recordAudit(tx, actor, "DELETE_EXERCISE_TYPE", exerciseType, nil)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func userExists(tx *sql.Tx, userID int64) (bool, error) {
+	var totalUsers int
+	err := tx.QueryRow(`SELECT COUNT(*) FROM users WHERE ID=$1`, userID).Scan(&totalUsers)
+
+	return totalUsers > 0, err
+}
+
+// overlaps whether any exercise of the source user overlaps one of the target user
+func (m *Merge) overlaps(tx *sql.Tx) (bool, error) {
+	var totalOverlapping int
+	err := tx.QueryRow(`SELECT COUNT(*) FROM exercises s JOIN exercises t ON t.USER_ID=$1 AND t.START_TIME <= s.FINISH_TIME AND s.START_TIME <= t.FINISH_TIME WHERE s.USER_ID=$2`, m.TargetUserID, m.SourceUserID).Scan(&totalOverlapping)
+
+	return totalOverlapping > 0, err
+}
+
+func (m *Merge) mergeUsers(actor string) error {
+	database, err := openDatabase()
+	if err != nil {
+		return err
+	}
+
+	tx, err := database.Begin()
+	if err != nil {
+		return err
+	}
+
+	for _, userID := range []int64{m.SourceUserID, m.TargetUserID} {
+		exists, err := userExists(tx, userID)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		if !exists {
+			tx.Rollback()
+			return ErrNoUserFound
+		}
+	}
+
+	// the target user can not do two exercises at once either
+	overlapping, err := m.overlaps(tx)
+	if err == nil && overlapping {
+		err = ErrMergeOverlapping
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	exerciseIDs, err := getExerciseIDsByUser(tx, m.SourceUserID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	before := map[int64]*history.State{}
+	for _, exerciseID := range exerciseIDs {
+		if err = archive.Check(tx, exerciseID); err == nil {
+			before[exerciseID], err = history.Load(tx, exerciseID)
+		}
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	// the source user keeps no exercises nor teams and is deactivated
+	statements := []struct {
+		query string
+		args  []interface{}
+	}{
+		{`UPDATE exercises SET USER_ID=$1, VERSION=VERSION+1 WHERE USER_ID=$2`, []interface{}{m.TargetUserID, m.SourceUserID}},
+		{`INSERT OR IGNORE INTO team_members (TEAM_ID, USER_ID) SELECT TEAM_ID, $1 FROM team_members WHERE USER_ID=$2`, []interface{}{m.TargetUserID, m.SourceUserID}},
+		{`DELETE FROM team_members WHERE USER_ID=$1`, []interface{}{m.SourceUserID}},
+		{`UPDATE users SET ACTIVE=0 WHERE ID=$1`, []interface{}{m.SourceUserID}},
+	}
+
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement.query, statement.args...); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	exerciseTypes := map[string]bool{}
+	for _, exerciseID := range exerciseIDs {
+		after, err := history.Load(tx, exerciseID)
+		if err == nil {
+			err = history.Record(tx, exerciseID, history.UpdateAction, actor, before[exerciseID], after)
+		}
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		exerciseTypes[after.ExerciseType] = true
+	}
+
+	// the target user may beat their records with the exercises of the source one
+	err = records.Forget(tx, m.SourceUserID)
+	for exerciseType := range exerciseTypes {
+		if err == nil {
+			_, err = records.Detect(tx, m.TargetUserID, exerciseType, 0)
+		}
+	}
+	if err == nil {
+		err = recordAudit(tx, actor, "MERGE_USERS", strconv.FormatInt(m.TargetUserID, 10), m)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func getExerciseIDsByUser(tx *sql.Tx, userID int64) ([]int64, error) {
+	result, err := tx.Query(`SELECT ID FROM exercises WHERE USER_ID=$1`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer result.Close()
+
+	exerciseIDs := []int64{}
+	for result.Next() {
+		var exerciseID int64
+		if err := result.Scan(&exerciseID); err != nil {
+			return nil, err
+		}
+
+		exerciseIDs = append(exerciseIDs, exerciseID)
+	}
+
+	return exerciseIDs, result.Err()
+}
+
+func deleteExercisesByUser(userID int64, actor string) (int64, error) {
+	database, err := openDatabase()
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := database.Begin()
+	if err != nil {
+		return 0, err
+	}
+
+	exerciseIDs, err := getExerciseIDsByUser(tx, userID)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	for _, exerciseID := range exerciseIDs {
+		err := archive.Check(tx, exerciseID)
+
+		var before *history.State
+		if err == nil {
+			before, err = history.Load(tx, exerciseID)
+		}
+		if err == nil {
+			_, err = tx.Exec(`DELETE FROM exercises WHERE ID=$1`, exerciseID)
+		}
+		if err == nil {
+			err = remove.DeleteChildren(tx, exerciseID)
+		}
+		if err == nil {
+			err = history.Record(tx, exerciseID, history.DeleteAction, actor, before, nil)
+		}
+		if err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+	}
+
+	deleted := int64(len(exerciseIDs))
+
+	err = records.Forget(tx, userID)
+	if err == nil {
+		err = recordAudit(tx, actor, "DELETE_USER_EXERCISES", strconv.FormatInt(userID, 10), map[string]int64{"deleted": deleted})
+	}
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	return deleted, tx.Commit()
+}
+
+func getAudit(limit int) ([]*AuditEntry, error) {
+	database, err := openDatabase()
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := database.Query(`SELECT ID, ACTOR, ACTION, TARGET, DETAILS, CREATED_AT FROM admin_audit ORDER BY ID DESC LIMIT $1`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer result.Close()
+
+	entries := []*AuditEntry{}
+	for result.Next() {
+		entry := &AuditEntry{}
+		var details string
+		if err := result.Scan(&entry.ID, &entry.Actor, &entry.Action, &entry.Target, &details, &entry.CreatedAt); err != nil {
+			return nil, err
+		}
+
+		entry.Details = json.RawMessage(details)
+		entries = append(entries, entry)
+	}
+
+	return entries, result.Err()
+}
+
+func response(w http.ResponseWriter, httpStatus int, response *Response, err error) {
+	if err != nil {
+		response.Error = err.Error()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(httpStatus)
+	json.NewEncoder(w).Encode(response)
+}
+
+// ExerciseTypesEndpoint function that lists the exercise types and their multiplication factors
+func ExerciseTypesEndpoint(w http.ResponseWriter, r *http.Request) {
+	newResponse := &Response{}
+
+	exerciseTypes, err := getExerciseTypes()
+	if err != nil {
+		response(w, http.StatusInternalServerError, newResponse, err)
+		return
+	}
+
+	newResponse.ExerciseTypes = exerciseTypes
+	response(w, http.StatusOK, newResponse, err)
+}
+
+// SaveExerciseTypeEndpoint function that creates an exercise type or changes its factors, the omitted ones are kept
+func SaveExerciseTypeEndpoint(w http.ResponseWriter, r *http.Request) {
+	newResponse := &Response{}
+	params := mux.Vars(r)
+
+	defer r.Body.Close()
+
+	if !isValidTypeName(params["type"]) {
+		response(w, http.StatusBadRequest, newResponse, ErrInvalidType)
+		return
+	}
+
+	// fields omitted from the body keep their stored value
+	exerciseType, err := getExerciseType(params["type"])
+	if err != nil {
+		response(w, http.StatusInternalServerError, newResponse, err)
+		return
+	}
+
+	if err = json.NewDecoder(r.Body).Decode(exerciseType); err != nil {
+		response(w, http.StatusBadRequest, newResponse, err)
+		return
+	}
+	exerciseType.Type = params["type"]
+
+	if exerciseType.MultiplicationFactor < 1 {
+		response(w, http.StatusBadRequest, newResponse, ErrInvalidMultiplicationFactor)
+		return
+	}
+
+	if exerciseType.DistanceFactor < 0 {
+		response(w, http.StatusBadRequest, newResponse, ErrInvalidDistanceFactor)
+		return
+	}
+
+	if exerciseType.LoadFactor < 0 {
+		response(w, http.StatusBadRequest, newResponse, ErrInvalidLoadFactor)
+		return
+	}
+
+	if exerciseType.EstimatedCaloriesFactor < 0 || exerciseType.EstimatedCaloriesFactor > 1 {
+		response(w, http.StatusBadRequest, newResponse, ErrInvalidEstimatedCaloriesFactor)
+		return
+	}
+
+	if exerciseType.MaxCaloriesPerMinute < 0 || exerciseType.MaxDuration < 0 {
+		response(w, http.StatusBadRequest, newResponse, ErrInvalidPlausibilityRule)
+		return
+	}
+
+	for _, met := range []float64{exerciseType.LowMET, exerciseType.ModerateMET, exerciseType.HighMET} {
+		if met < 0 || met > maxMET {
+			response(w, http.StatusBadRequest, newResponse, ErrInvalidMET)
+			return
+		}
+	}
+
+	if !plausibility.ValidMode(exerciseType.PlausibilityMode) {
+		response(w, http.StatusBadRequest, newResponse, ErrInvalidPlausibilityMode)
+		return
+	}
+
+	err = exerciseType.saveExerciseType(auth.FromRequest(r).String())
+	if err != nil {
+		response(w, http.StatusInternalServerError, newResponse, err)
+		return
+	}
+
+	newResponse.ExerciseType = exerciseType
+	response(w, http.StatusOK, newResponse, err)
+}
+
+// DeleteExerciseTypeEndpoint function that deletes an exercise type without exercises
+func DeleteExerciseTypeEndpoint(w http.ResponseWriter, r *http.Request) {
+	newResponse := &Response{}
+	params := mux.Vars(r)
+
+	err := deleteExerciseType(params["type"], auth.FromRequest(r).String())
+	if err == ErrNoTypeFound {
+		response(w, http.StatusNotFound, newResponse, err)
+		return
+	}
+	if err == ErrTypeInUse {
+		response(w, http.StatusConflict, newResponse, err)
+		return
+	}
+	if err != nil {
+		response(w, http.StatusInternalServerError, newResponse, err)
+		return
+	}
+
+	response(w, http.StatusOK, newResponse, err)
+}
+
+// RecomputeRankingEndpoint function that archives again the ranking of a finished season
+func RecomputeRankingEndpoint(w http.ResponseWriter, r *http.Request) {
+	newResponse := &Response{}
+	params := mux.Vars(r)
+
+	ranking, err := rank.SnapshotSeason(params["seasonId"], func(tx *sql.Tx, ranking []*rank.User) error {
+		return recordAudit(tx, auth.FromRequest(r).String(), "RECOMPUTE_RANKING", params["seasonId"], map[string]int{"rankedUsers": len(ranking)})
+	})
+	if err == rank.ErrInvalidSeason || err == rank.ErrSeasonNotFinished {
+		response(w, http.StatusBadRequest, newResponse, err)
+		return
+	}
+	if err != nil {
+		response(w, http.StatusInternalServerError, newResponse, err)
+		return
+	}
+
+	newResponse.Ranking = ranking
+	response(w, http.StatusOK, newResponse, err)
+}
+
+// MergeUsersEndpoint function that moves the exercises and teams of a duplicated user into another
+func MergeUsersEndpoint(w http.ResponseWriter, r *http.Request) {
+	newResponse := &Response{}
+	merge := &Merge{}
+
+	defer r.Body.Close()
+
+	if err := json.NewDecoder(r.Body).Decode(merge); err != nil {
+		response(w, http.StatusBadRequest, newResponse, err)
+		return
+	}
+
+	if merge.SourceUserID == 0 || merge.TargetUserID == 0 {
+		response(w, http.StatusBadRequest, newResponse, ErrInvalidUserID)
+		return
+	}
+
+	if merge.SourceUserID == merge.TargetUserID {
+		response(w, http.StatusBadRequest, newResponse, ErrSameUser)
+		return
+	}
+
+	err := merge.mergeUsers(auth.FromRequest(r).String())
+	if err == ErrNoUserFound {
+		response(w, http.StatusNotFound, newResponse, err)
+		return
+	}
+	if err == archive.ErrArchivedExercise || err == ErrMergeOverlapping {
+		response(w, http.StatusConflict, newResponse, err)
+		return
+	}
+	if err != nil {
+		response(w, http.StatusInternalServerError, newResponse, err)
+		return
+	}
+
+	newResponse.Merge = merge
+	response(w, http.StatusOK, newResponse, err)
+}
+
+// DeleteUserExercisesEndpoint function that deletes every exercise of a user
+func DeleteUserExercisesEndpoint(w http.ResponseWriter, r *http.Request) {
+	newResponse := &Response{}
+	params := mux.Vars(r)
+
+	userID, err := strconv.ParseInt(params["userId"], 10, 64)
+	if err != nil {
+		response(w, http.StatusBadRequest, newResponse, ErrInvalidUserID)
+		return
+	}
+
+	deleted, err := deleteExercisesByUser(userID, auth.FromRequest(r).String())
+	if err == archive.ErrArchivedExercise {
+		response(w, http.StatusConflict, newResponse, err)
+		return
+	}
+	if err != nil {
+		response(w, http.StatusInternalServerError, newResponse, err)
+		return
+	}
+
+	newResponse.Deleted = &deleted
+	response(w, http.StatusOK, newResponse, err)
+}
+
+// AuditEndpoint function that lists the latest operations performed through the admin api
+func AuditEndpoint(w http.ResponseWriter, r *http.Request) {
+	newResponse := &Response{}
+
+	limit := defaultAuditLimit
+	if r.URL.Query().Get("limit") != "" {
+		var err error
+		limit, err = strconv.Atoi(r.URL.Query().Get("limit"))
+		if err != nil || limit < 1 {
+			response(w, http.StatusBadRequest, newResponse, ErrInvalidLimit)
+			return
+		}
+	}
+
+	audit, err := getAudit(limit)
+	if err != nil {
+		response(w, http.StatusInternalServerError, newResponse, err)
+		return
+	}
+
+	newResponse.Audit = audit
+	response(w, http.StatusOK, newResponse, err)
+}
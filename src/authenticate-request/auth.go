@@ -0,0 +1,264 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Role Role of the authenticated principal
+type Role string
+
+type contextKey string
+
+var (
+	// ErrMissingCredentials Error when neither a bearer token nor an api key is received
+	ErrMissingCredentials = errors.New("Missing bearer token or api key")
+	// ErrInvalidToken Error when the bearer token is malformed or its signature does not match
+	ErrInvalidToken = errors.New("Invalid bearer token")
+	// ErrExpiredToken Error when the bearer token is expired
+	ErrExpiredToken = errors.New("Expired bearer token")
+	// ErrMissingExpiration Error when the bearer token has no exp claim
+	ErrMissingExpiration = errors.New("Invalid bearer token must have an expiration")
+	// ErrInvalidAPIKey Error when the api key is not configured
+	ErrInvalidAPIKey = errors.New("Invalid api key")
+	// ErrForbidden Error when the principal acts on behalf of another user
+	ErrForbidden = errors.New("You are not allowed to act on behalf of this user")
+	// ErrInsufficientRole Error when the principal lacks the role required by a route
+	ErrInsufficientRole = errors.New("You do not have the role required for this operation")
+
+	validRoles = map[Role]bool{
+		UserRole:  true,
+		AdminRole: true,
+	}
+)
+
+const (
+	// UserRole Role of regular users, limited to their own exercises
+	UserRole Role = "USER"
+	// AdminRole Role allowed to act on behalf of any user
+	AdminRole Role = "ADMIN"
+
+	// JWTKeyEnv environment variable holding the HS256 key used to verify bearer tokens
+	JWTKeyEnv = "EXERCISE_API_JWT_KEY"
+	// APIKeysEnv environment variable holding comma separated key:ROLE pairs for service clients
+	APIKeysEnv = "EXERCISE_API_KEYS"
+	// APIKeyHeader header carrying the api key of service clients
+	APIKeyHeader = "X-API-Key"
+
+	principalKey contextKey = "principal"
+)
+
+// Principal the authenticated caller of a request
+type Principal struct {
+	// UserID id of the user the token was issued to, 0 for service clients
+	UserID int64
+	// Role of the caller
+	Role Role
+	// KeyID fingerprint of the api key of service clients, empty for users
+	KeyID string
+}
+
+// Claims payload of the bearer tokens
+type Claims struct {
+	Subject   string `json:"sub"`
+	Role      Role   `json:"role"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+type header struct {
+	Algorithm string `json:"alg"`
+}
+
+// Response for authentication errors
+type Response struct {
+	Error string `json:"error,omitempty"`
+}
+
+// CanActFor whether the principal may create or modify the exercises of userID
+func (p *Principal) CanActFor(userID int64) bool {
+	if p == nil {
+		return false
+	}
+
+	return p.Role == AdminRole || (p.UserID != 0 && p.UserID == userID)
+}
+
+// String identifies the principal in audit trails
+func (p *Principal) String() string {
+	if p.UserID == 0 {
+		return fmt.Sprintf("api-key:%s", p.KeyID)
+	}
+
+	return fmt.Sprintf("user:%d", p.UserID)
+}
+
+// HasRole whether the principal has any of the roles
+func (p *Principal) HasRole(roles ...Role) bool {
+	if p == nil {
+		return false
+	}
+
+	for _, role := range roles {
+		if p.Role == role {
+			return true
+		}
+	}
+
+	return false
+}
+
+// FromRequest the principal authenticated by Middleware
+func FromRequest(r *http.Request) *Principal {
+	principal, _ := r.Context().Value(principalKey).(*Principal)
+	return principal
+}
+
+func decodeSegment(segment string, value interface{}) error {
+	decoded, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return ErrInvalidToken
+	}
+
+	if err := json.Unmarshal(decoded, value); err != nil {
+		return ErrInvalidToken
+	}
+
+	return nil
+}
+
+func verifyToken(token string, key []byte) (*Principal, error) {
+	segments := strings.Split(token, ".")
+	if len(segments) != 3 || len(key) == 0 {
+		return nil, ErrInvalidToken
+	}
+
+	tokenHeader := &header{}
+	if err := decodeSegment(segments[0], tokenHeader); err != nil {
+		return nil, err
+	}
+
+	if tokenHeader.Algorithm != "HS256" {
+		return nil, ErrInvalidToken
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(segments[2])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(segments[0] + "." + segments[1]))
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return nil, ErrInvalidToken
+	}
+
+	claims := &Claims{}
+	if err := decodeSegment(segments[1], claims); err != nil {
+		return nil, err
+	}
+
+	// tokens that never expire can not be revoked
+	if claims.ExpiresAt == 0 {
+		return nil, ErrMissingExpiration
+	}
+
+	if time.Now().Unix() >= claims.ExpiresAt {
+		return nil, ErrExpiredToken
+	}
+
+	userID, err := strconv.ParseInt(claims.Subject, 10, 64)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	if claims.Role == "" {
+		claims.Role = UserRole
+	}
+
+	if !validRoles[claims.Role] {
+		return nil, ErrInvalidToken
+	}
+
+	return &Principal{UserID: userID, Role: claims.Role}, nil
+}
+
+// keyID identifies an api key in audit trails without revealing it
+func keyID(apiKey string) string {
+	sum := sha256.Sum256([]byte(apiKey))
+	return hex.EncodeToString(sum[:6])
+}
+
+func verifyAPIKey(apiKey string, configuredKeys string) (*Principal, error) {
+	for _, pair := range strings.Split(configuredKeys, ",") {
+		separator := strings.LastIndex(pair, ":")
+		if separator < 1 {
+			continue
+		}
+
+		key, role := strings.TrimSpace(pair[:separator]), Role(strings.TrimSpace(pair[separator+1:]))
+		if subtle.ConstantTimeCompare([]byte(key), []byte(apiKey)) == 1 && validRoles[role] {
+			return &Principal{Role: role, KeyID: keyID(key)}, nil
+		}
+	}
+
+	return nil, ErrInvalidAPIKey
+}
+
+func authenticate(r *http.Request) (*Principal, error) {
+	if apiKey := r.Header.Get(APIKeyHeader); apiKey != "" {
+		return verifyAPIKey(apiKey, os.Getenv(APIKeysEnv))
+	}
+
+	authorization := r.Header.Get("Authorization")
+	if !strings.HasPrefix(authorization, "Bearer ") {
+		return nil, ErrMissingCredentials
+	}
+
+	return verifyToken(strings.TrimPrefix(authorization, "Bearer "), []byte(os.Getenv(JWTKeyEnv)))
+}
+
+func response(w http.ResponseWriter, httpStatus int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(httpStatus)
+	json.NewEncoder(w).Encode(&Response{Error: err.Error()})
+}
+
+// Middleware rejects requests without a valid bearer token or api key
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, err := authenticate(r)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="exerciseAPI"`)
+			response(w, http.StatusUnauthorized, err)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalKey, principal)))
+	})
+}
+
+// RequireRole rejects requests whose principal has none of the roles, must run after Middleware
+func RequireRole(roles ...Role) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !FromRequest(r).HasRole(roles...) {
+				response(w, http.StatusForbidden, ErrInsufficientRole)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
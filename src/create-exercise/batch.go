@@ -0,0 +1,325 @@
+package create
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"unicode"
+
+	auth "../authenticate-request"
+	records "../exercise-records"
+)
+
+// BatchMode how a batch reacts to invalid items
+type BatchMode string
+
+// ResultStatus outcome of an item of a batch
+type ResultStatus string
+
+var (
+	// ErrInvalidBatchMode Error when mode param is invalid
+	ErrInvalidBatchMode = errors.New("Invalid mode must be ALL_OR_NOTHING or BEST_EFFORT")
+	// ErrEmptyBatch Error when the batch has no exercises
+	ErrEmptyBatch = errors.New("The batch has no exercises")
+	// ErrBatchTooLarge Error when the batch has more exercises than allowed
+	ErrBatchTooLarge = errors.New("The batch has more than 1000 exercises")
+	// ErrBatchOverlapping Error when an exercise overlaps another one of the same batch
+	ErrBatchOverlapping = errors.New("The exercise overlaps with another one of the batch")
+	// ErrBatchRejected Error of valid items not created because other items of the batch are invalid
+	ErrBatchRejected = errors.New("Not created as other exercises of the batch are invalid")
+
+	validBatchModes = map[BatchMode]bool{
+		AllOrNothingMode: true,
+		BestEffortMode:   true,
+	}
+)
+
+const (
+	// AllOrNothingMode no exercise is created unless all of them are valid
+	AllOrNothingMode BatchMode = "ALL_OR_NOTHING"
+	// BestEffortMode valid exercises are created, invalid ones are reported
+	BestEffortMode BatchMode = "BEST_EFFORT"
+
+	// CreatedStatus the exercise was created
+	CreatedStatus ResultStatus = "CREATED"
+	// FailedStatus the exercise was not created
+	FailedStatus ResultStatus = "FAILED"
+
+	maxBatchSize = 1000
+)
+
+// Result outcome of an item of a batch
+type Result struct {
+	// Index position of the item in the batch
+	Index int `json:"index"`
+	// Line of the CSV file the item was read from
+	Line int `json:"line,omitempty"`
+	// Status of the item
+	Status ResultStatus `json:"status"`
+	// Exercise created for the item
+	Exercise *Exercise `json:"exercise,omitempty"`
+	// NewRecords personal records beaten by the exercise created for the item
+	NewRecords []*records.Record `json:"newRecords,omitempty"`
+	// Error why the item was not created
+	Error string `json:"error,omitempty"`
+}
+
+// batchItem exercise of a batch and the error found while reading it, if any
+type batchItem struct {
+	exercise *Exercise
+	err      error
+}
+
+// decodeItem an exercise of the batch, keeping on the item why it can not be read
+func decodeItem(raw []byte) *batchItem {
+	item := &batchItem{exercise: &Exercise{}}
+	item.err = json.Unmarshal(raw, item.exercise)
+
+	return item
+}
+
+// decodeArray items of a JSON array, a syntax error leaves the rest of the array unreadable
+func decodeArray(reader *bufio.Reader) ([]*batchItem, error) {
+	decoder := json.NewDecoder(reader)
+	if _, err := decoder.Token(); err != nil {
+		return nil, err
+	}
+
+	items := []*batchItem{}
+	for decoder.More() {
+		if len(items) == maxBatchSize {
+			return nil, ErrBatchTooLarge
+		}
+
+		var raw json.RawMessage
+		if err := decoder.Decode(&raw); err != nil {
+			return nil, err
+		}
+
+		items = append(items, decodeItem(raw))
+	}
+
+	return items, nil
+}
+
+// decodeStream items of an NDJSON stream, one per line, a malformed line only fails its item
+func decodeStream(reader *bufio.Reader) ([]*batchItem, error) {
+	items := []*batchItem{}
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			if len(items) == maxBatchSize {
+				return nil, ErrBatchTooLarge
+			}
+
+			items = append(items, decodeItem(line))
+		}
+
+		if err == io.EOF {
+			return items, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func decodeBatch(body io.Reader) ([]*batchItem, error) {
+	reader := bufio.NewReader(body)
+
+	// a JSON array starts with '[', otherwise the body is a stream of objects
+	isArray := false
+	for {
+		next, err := reader.Peek(1)
+		if err != nil {
+			return nil, ErrEmptyBatch
+		}
+
+		if !unicode.IsSpace(rune(next[0])) {
+			isArray = next[0] == '['
+			break
+		}
+
+		reader.ReadByte()
+	}
+
+	decode := decodeStream
+	if isArray {
+		decode = decodeArray
+	}
+
+	items, err := decode(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(items) == 0 {
+		return nil, ErrEmptyBatch
+	}
+
+	return items, nil
+}
+
+func overlaps(e *Exercise, other *Exercise) bool {
+	if e.UserID != other.UserID {
+		return false
+	}
+
+	finishDate := addDurationToDate(e.StartTime, e.Duration)
+	otherFinishDate := addDurationToDate(other.StartTime, other.Duration)
+
+	return !e.StartTime.After(otherFinishDate) && !other.StartTime.After(finishDate)
+}
+
+// validateBatch validates every item on its own and against the previous valid items of the batch
+func validateBatch(items []*batchItem, principal *auth.Principal) {
+	accepted := []*Exercise{}
+
+	for _, item := range items {
+		if item.err != nil {
+			continue
+		}
+
+		if !principal.CanActFor(item.exercise.UserID) {
+			item.err = auth.ErrForbidden
+			continue
+		}
+
+		if item.err = item.exercise.validateCreateExerciseRequest(); item.err != nil {
+			continue
+		}
+
+		for _, other := range accepted {
+			if overlaps(item.exercise, other) {
+				item.err = ErrBatchOverlapping
+				break
+			}
+		}
+
+		if item.err == nil {
+			accepted = append(accepted, item.exercise)
+		}
+	}
+}
+
+func createAllOrNothing(items []*batchItem, actor string) error {
+	for _, item := range items {
+		if item.err != nil {
+			for _, valid := range items {
+				if valid.err == nil {
+					valid.err = ErrBatchRejected
+				}
+			}
+
+			return nil
+		}
+	}
+
+	database, err := openDatabase()
+	if err != nil {
+		return err
+	}
+
+	tx, err := database.Begin()
+	if err != nil {
+		return err
+	}
+
+	for _, item := range items {
+		if err := item.exercise.insertExercise(tx, actor); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func createBestEffort(items []*batchItem, actor string) {
+	for _, item := range items {
+		if item.err == nil {
+			item.err = item.exercise.createExercise(actor)
+		}
+	}
+}
+
+// processBatch creates the exercises of a batch, returning the per item results and the http status
+func processBatch(items []*batchItem, mode BatchMode, principal *auth.Principal) ([]*Result, int, error) {
+	validateBatch(items, principal)
+
+	if mode == AllOrNothingMode {
+		if err := createAllOrNothing(items, principal.String()); err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+	} else {
+		createBestEffort(items, principal.String())
+	}
+
+	results := []*Result{}
+	failed := 0
+	for index, item := range items {
+		result := &Result{Index: index, Status: CreatedStatus, Exercise: item.exercise, NewRecords: item.exercise.newRecords}
+		if item.err != nil {
+			result.Status = FailedStatus
+			result.Exercise = nil
+			result.NewRecords = nil
+			result.Error = item.err.Error()
+			failed++
+		}
+
+		results = append(results, result)
+	}
+
+	switch {
+	case failed == 0:
+		return results, http.StatusCreated, nil
+	case failed == len(items):
+		return results, http.StatusUnprocessableEntity, nil
+	default:
+		return results, http.StatusMultiStatus, nil
+	}
+}
+
+func getBatchMode(r *http.Request) (BatchMode, error) {
+	mode := AllOrNothingMode
+	if r.URL.Query().Get("mode") != "" {
+		mode = BatchMode(r.URL.Query().Get("mode"))
+	}
+
+	if !validBatchModes[mode] {
+		return "", ErrInvalidBatchMode
+	}
+
+	return mode, nil
+}
+
+// BatchEndpoint function that creates the exercises of a JSON array or NDJSON stream
+func BatchEndpoint(w http.ResponseWriter, r *http.Request) {
+	newResponse := &Response{}
+
+	defer r.Body.Close()
+
+	mode, err := getBatchMode(r)
+	if err != nil {
+		response(w, http.StatusBadRequest, newResponse, err)
+		return
+	}
+
+	items, err := decodeBatch(r.Body)
+	if err != nil {
+		response(w, http.StatusBadRequest, newResponse, err)
+		return
+	}
+
+	results, status, err := processBatch(items, mode, auth.FromRequest(r))
+	if err != nil {
+		response(w, status, newResponse, err)
+		return
+	}
+
+	newResponse.Results = results
+	response(w, status, newResponse, err)
+}
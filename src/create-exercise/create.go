@@ -7,8 +7,23 @@ import (
 	"fmt"
 	"net/http"
 	"os"
-	"regexp"
 	"time"
+
+	auth "../authenticate-request"
+	archive "../exercise-archive"
+	calories "../exercise-calories"
+	heartrate "../exercise-heartrate"
+	history "../exercise-history"
+	metrics "../exercise-metrics"
+	plausibility "../exercise-plausibility"
+	records "../exercise-records"
+	text "../exercise-text"
+	version "../exercise-version"
+	circuits "../manage-circuits"
+	sets "../manage-sets"
+	swims "../manage-swims"
+	tags "../manage-tags"
+	workout "../parse-workout"
 )
 
 // ExerciseType Type of the Exercise
@@ -19,8 +34,6 @@ var (
 	ErrMissingUserID = errors.New("Missing userId")
 	// ErrMissingDescription Error when description field is not received
 	ErrMissingDescription = errors.New("Missing description")
-	// ErrInvalidDescription Error when description field is not received
-	ErrInvalidDescription = errors.New("Invalid description not an alphanumeric string")
 	// ErrMissingType Error when type field is not received
 	ErrMissingType = errors.New("Missing type")
 	// ErrInvalidType Error when type field is invalid
@@ -31,17 +44,14 @@ var (
 	ErrInvalidStartTime = errors.New("Invalid startTime format must be ISO8601")
 	// ErrMissingDuration Error when duration field is not received
 	ErrMissingDuration = errors.New("Missing duration")
-	// ErrMissingCalories Error when calories field is not received
-	ErrMissingCalories = errors.New("Missing calories")
 	// ErrExerciseOverlapping Error when a new exercise overlaps a saved one
 	ErrExerciseOverlapping = errors.New("The exercise that you intended to create overlaps with an existing one")
-
-	validTypes = map[ExerciseType]bool{
-		RunningType:          true,
-		SwimmingType:         true,
-		StrenghtTrainingType: true,
-		CircuitTrainingType:  true,
-	}
+	// ErrUnknownUser Error when userId does not belong to a registered user
+	ErrUnknownUser = errors.New("The user of the exercise does not exists")
+	// ErrInactiveUser Error when userId belongs to a deactivated user
+	ErrInactiveUser = errors.New("The user of the exercise is deactivated")
+	// ErrInvalidTimezone Error when timezone field is not an IANA time zone
+	ErrInvalidTimezone = errors.New("Invalid timezone must be an IANA time zone")
 )
 
 const (
@@ -61,29 +71,64 @@ type Exercise struct {
 	ID int64 `json:"id"`
 	// UserID id field of User
 	UserID int64 `json:"userId"`
-	// Description of the Exercise
+	// Description of the Exercise, in any language
 	Description string `json:"description"`
+	// Notes free text about the exercise
+	Notes string `json:"notes,omitempty"`
+	// Tags labels of the exercise, lowercase
+	Tags []string `json:"tags,omitempty"`
 	// ExerciseType type of the exercise
 	ExerciseType ExerciseType `json:"type"`
-	// StartTime time when exercise starts
+	// StartTime time when exercise starts, stored in UTC
 	StartTime time.Time `json:"startTime"`
+	// Timezone IANA time zone the exercise took place in, the one of the user when not received
+	Timezone string `json:"timezone,omitempty"`
 	// Duration duration of the exercise
 	Duration int64 `json:"duration"`
-	// Calories burnt on the exercise
+	// Calories burnt on the exercise, estimated from the intensity when not received
 	Calories int64 `json:"calories"`
+	// CaloriesEstimated whether the calories were estimated instead of measured
+	CaloriesEstimated bool `json:"caloriesEstimated,omitempty"`
+	// Intensity of the exercise, LOW, MODERATE or HIGH, the calories are estimated with
+	Intensity calories.Intensity `json:"intensity,omitempty"`
+	// Distance meters covered on the exercise
+	Distance float64 `json:"distance,omitempty"`
+	// Metrics speed and pace derived from the distance
+	Metrics *metrics.Metrics `json:"metrics,omitempty"`
+	// Sets performed on a strength training exercise
+	Sets []*sets.Set `json:"sets,omitempty"`
+	// Volume kilograms lifted on the sets
+	Volume float64 `json:"volume,omitempty"`
+	// Circuit stations and rounds of a circuit training exercise
+	Circuit *circuits.Circuit `json:"circuit,omitempty"`
+	// Swim pool length, laps and strokes of a swimming exercise
+	Swim *swims.Swim `json:"swim,omitempty"`
+	// Status ACCEPTED or APPROVED when ranked, FLAGGED until reviewed, REJECTED when not ranked
+	Status plausibility.Status `json:"status,omitempty"`
+	// FlagReason rule of the type the exercise breaks when flagged
+	FlagReason string `json:"flagReason,omitempty"`
+	// HeartRate summary of the heart rate recorded on an uploaded workout
+	HeartRate *heartrate.HeartRate `json:"heartRate,omitempty"`
+	// Laps recorded by the device on an uploaded workout, ignored on requests
+	Laps []*workout.Lap `json:"laps,omitempty"`
+	// Version incremented on every modification, sent as ETag
+	Version int64 `json:"-"`
+	// newRecords personal records beaten by the exercise
+	newRecords []*records.Record
+	// heartRateSamples saved along the exercise, only received on imports and uploads
+	heartRateSamples []*heartrate.Sample
+	// keepEstimate whether the calories received are an estimate, only an import keeps them as such
+	keepEstimate bool
 }
 
 // Response for /exercise
 type Response struct {
-	Exercise *Exercise `json:"exercise,omitempty"`
-	Error    string    `json:"error,omitempty"`
-}
-
-func isAlphaNumericString(description string) bool {
-	AlphaNumericStringRegex := `^[A-Za-z0-9\s]+$`
-	AlphaNumericRegex := regexp.MustCompile(AlphaNumericStringRegex)
-
-	return AlphaNumericRegex.MatchString(description)
+	Exercise *Exercise        `json:"exercise,omitempty"`
+	Results  []*Result        `json:"results,omitempty"`
+	Workout  *workout.Workout `json:"workout,omitempty"`
+	// NewRecords personal records beaten by the exercise created
+	NewRecords []*records.Record `json:"newRecords,omitempty"`
+	Error      string            `json:"error,omitempty"`
 }
 
 func addDurationToDate(date time.Time, duration int64) time.Time {
@@ -91,6 +136,15 @@ func addDurationToDate(date time.Time, duration int64) time.Time {
 	return afterDurationSeconds
 }
 
+func openDatabase() (*sql.DB, error) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+
+	return sql.Open("sqlite3", fmt.Sprintf("%s/egym.db", dir))
+}
+
 func checkExerciseOverlapping(userID int64, startDate time.Time, finishDate time.Time) (bool, error) {
 	var totalExercisesCollatingOnStart int
 	var totalExercisesCollatingOnFinish int
@@ -117,6 +171,116 @@ func checkExerciseOverlapping(userID int64, startDate time.Time, finishDate time
 	return false, nil
 }
 
+func isValidType(exerciseType ExerciseType) (bool, error) {
+	var totalTypes int
+
+	dir, err := os.Getwd()
+	if err != nil {
+		return false, err
+	}
+	database, err := sql.Open("sqlite3", fmt.Sprintf("%s/egym.db", dir))
+	if err != nil {
+		return false, err
+	}
+
+	sqlStatement := `SELECT COUNT(*) FROM exercise_types WHERE TYPE=$1;`
+	err = database.QueryRow(sqlStatement, exerciseType).Scan(&totalTypes)
+
+	return totalTypes > 0, err
+}
+
+// resolveTimezone defaults the time zone of the exercise to the one of its user
+func (e *Exercise) resolveTimezone() error {
+	if e.Timezone == "" {
+		database, err := openDatabase()
+		if err != nil {
+			return err
+		}
+
+		if err = database.QueryRow(`SELECT TIMEZONE FROM users WHERE ID=$1`, e.UserID).Scan(&e.Timezone); err != nil {
+			return err
+		}
+	}
+
+	if _, err := time.LoadLocation(e.Timezone); err != nil {
+		return ErrInvalidTimezone
+	}
+
+	return nil
+}
+
+func checkUserIsActive(userID int64) error {
+	var active bool
+
+	dir, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	database, err := sql.Open("sqlite3", fmt.Sprintf("%s/egym.db", dir))
+	if err != nil {
+		return err
+	}
+
+	sqlStatement := `SELECT ACTIVE FROM users WHERE ID=$1;`
+	err = database.QueryRow(sqlStatement, userID).Scan(&active)
+	if err == sql.ErrNoRows {
+		return ErrUnknownUser
+	}
+	if err != nil {
+		return err
+	}
+
+	if !active {
+		return ErrInactiveUser
+	}
+
+	return nil
+}
+
+// estimateCalories from the MET of the type at the intensity of the exercise and the weight of the user
+func (e *Exercise) estimateCalories() error {
+	database, err := openDatabase()
+	if err != nil {
+		return err
+	}
+
+	e.Calories, err = calories.Estimate(database, e.UserID, string(e.ExerciseType), e.Intensity, e.Duration)
+	if err != nil {
+		return err
+	}
+
+	e.CaloriesEstimated = true
+
+	return nil
+}
+
+// checkPlausibility of the values against the rules of the type, flagging the exercise when they are broken
+func (e *Exercise) checkPlausibility() error {
+	database, err := openDatabase()
+	if err != nil {
+		return err
+	}
+
+	e.FlagReason, err = plausibility.Check(database, string(e.ExerciseType), e.StartTime, e.Duration, e.Calories, e.Distance)
+	if err != nil {
+		return err
+	}
+
+	e.Status = plausibility.StatusOf(e.FlagReason)
+
+	return nil
+}
+
+// checkArchived verifies the exercise does not start in a season whose ranking is already archived
+func (e *Exercise) checkArchived() error {
+	database, err := openDatabase()
+	if err != nil {
+		return err
+	}
+
+	return archive.CheckStartTime(database, e.StartTime)
+}
+
 func (e *Exercise) validateCreateExerciseRequest() error {
 	if e.UserID == 0 {
 		return ErrMissingUserID
@@ -126,15 +290,30 @@ func (e *Exercise) validateCreateExerciseRequest() error {
 		return ErrMissingDescription
 	}
 
-	if !isAlphaNumericString(e.Description) {
-		return ErrInvalidDescription
+	description, err := text.Description(e.Description)
+	if err != nil {
+		return err
+	}
+	e.Description = description
+
+	if e.Notes, err = text.Notes(e.Notes); err != nil {
+		return err
+	}
+
+	if e.Tags, err = tags.Validate(e.Tags); err != nil {
+		return err
 	}
 
 	if e.ExerciseType == "" {
 		return ErrMissingType
 	}
 
-	if !validTypes[e.ExerciseType] {
+	isValid, err := isValidType(e.ExerciseType)
+	if err != nil {
+		return err
+	}
+
+	if !isValid {
 		return ErrInvalidType
 	}
 
@@ -142,12 +321,67 @@ func (e *Exercise) validateCreateExerciseRequest() error {
 		return ErrMissingStartTime
 	}
 
+	// the offset sent is not kept, the time zone of the exercise is
+	e.StartTime = e.StartTime.UTC()
+
 	if e.Duration == 0 {
 		return ErrMissingDuration
 	}
 
+	if err := calories.ValidateIntensity(e.Intensity); err != nil {
+		return err
+	}
+
+	// the distance of a pool swim is the one swum on its laps
+	if e.Swim != nil && e.Distance == 0 {
+		e.Distance = e.Swim.Distance()
+	}
+
+	if err := metrics.ValidateDistance(string(e.ExerciseType), e.Duration, e.Distance); err != nil {
+		return err
+	}
+
+	if err := swims.Validate(string(e.ExerciseType), e.Distance, e.Swim); err != nil {
+		return err
+	}
+
+	if err := sets.Validate(string(e.ExerciseType), e.Sets); err != nil {
+		return err
+	}
+
+	if err := circuits.Validate(string(e.ExerciseType), e.Duration, e.Circuit); err != nil {
+		return err
+	}
+
+	if e.heartRateSamples != nil {
+		if err := heartrate.Validate(e.StartTime, e.Duration, e.heartRateSamples); err != nil {
+			return err
+		}
+	}
+
+	if err := checkUserIsActive(e.UserID); err != nil {
+		return err
+	}
+
+	if err := e.resolveTimezone(); err != nil {
+		return err
+	}
+
+	// only the calories estimated here or imported as estimated are marked as an estimate, not received ones
+	e.CaloriesEstimated = e.keepEstimate && e.Calories > 0
 	if e.Calories == 0 {
-		return ErrMissingCalories
+		if err := e.estimateCalories(); err != nil {
+			return err
+		}
+	}
+
+	// checked once the calories are estimated and the distance of a swim is known
+	if err := e.checkPlausibility(); err != nil {
+		return err
+	}
+
+	if err := e.checkArchived(); err != nil {
+		return err
 	}
 
 	finishDate := addDurationToDate(e.StartTime, e.Duration)
@@ -159,30 +393,89 @@ func (e *Exercise) validateCreateExerciseRequest() error {
 	return nil
 }
 
-func (e *Exercise) createExercise() error {
+func (e *Exercise) insertExercise(tx *sql.Tx, actor string) error {
 	finishDate := addDurationToDate(e.StartTime, e.Duration) // esto podria estar siendo redundante
-	dir, err := os.Getwd()
+
+	statement, err := tx.Prepare("INSERT INTO exercises (USER_ID, DESCRIPTION, NOTES, TYPE, START_TIME, FINISH_TIME, TIMEZONE, DURATION, CALORIES, CALORIES_ESTIMATED, INTENSITY, DISTANCE, STATUS, FLAG_REASON) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)")
 	if err != nil {
 		return err
 	}
-	database, err := sql.Open("sqlite3", fmt.Sprintf("%s/egym.db", dir))
+
+	result, err := statement.Exec(e.UserID, e.Description, e.Notes, e.ExerciseType, e.StartTime, finishDate, e.Timezone, e.Duration, e.Calories, e.CaloriesEstimated, e.Intensity, e.Distance, e.Status, e.FlagReason)
 	if err != nil {
 		return err
 	}
 
-	statement, err := database.Prepare("INSERT INTO exercises (USER_ID, DESCRIPTION, TYPE, START_TIME, FINISH_TIME, DURATION, CALORIES) VALUES (?, ?, ?, ?, ?, ?, ?)")
+	e.ID, err = result.LastInsertId()
 	if err != nil {
 		return err
 	}
 
-	result, err := statement.Exec(e.UserID, e.Description, e.ExerciseType, e.StartTime, finishDate, e.Duration, e.Calories)
+	e.Metrics = metrics.Calculate(string(e.ExerciseType), e.Duration, e.Distance)
+
+	if err = sets.Insert(tx, e.ID, e.Sets); err != nil {
+		return err
+	}
+	e.Volume = sets.Volume(e.Sets)
+
+	if e.Circuit != nil {
+		if err = circuits.Save(tx, e.ID, e.Circuit); err != nil {
+			return err
+		}
+		e.Circuit.Expand()
+	}
+
+	if e.Swim != nil {
+		if err = swims.Save(tx, e.ID, e.Swim); err != nil {
+			return err
+		}
+		e.Swim.Derive(e.Duration)
+	}
+
+	if err = tags.Save(tx, e.ID, e.Tags); err != nil {
+		return err
+	}
+
+	if len(e.heartRateSamples) > 0 {
+		if e.HeartRate, err = heartrate.Save(tx, e.ID, e.UserID, e.heartRateSamples); err != nil {
+			return err
+		}
+	}
+
+	if e.newRecords, err = records.Detect(tx, e.UserID, string(e.ExerciseType), e.ID); err != nil {
+		return err
+	}
+
+	after, err := history.Load(tx, e.ID)
 	if err != nil {
 		return err
 	}
+	e.Version = after.Version
 
-	e.ID, err = result.LastInsertId()
+	return history.Record(tx, e.ID, history.CreateAction, actor, nil, after)
+}
 
-	return err
+func (e *Exercise) createExercise(actor string) error {
+	dir, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	database, err := sql.Open("sqlite3", fmt.Sprintf("%s/egym.db", dir))
+	if err != nil {
+		return err
+	}
+
+	tx, err := database.Begin()
+	if err != nil {
+		return err
+	}
+
+	if err = e.insertExercise(tx, actor); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
 }
 
 func response(w http.ResponseWriter, httpStatus int, response *Response, err error) {
@@ -206,21 +499,33 @@ func ExerciseEndpoint(w http.ResponseWriter, r *http.Request) {
 		response(w, http.StatusBadRequest, newResponse, err)
 		return
 	}
+	exercise.Laps = nil
 
 	w.Header().Set("Content-Type", "application/json")
 
+	if !auth.FromRequest(r).CanActFor(exercise.UserID) {
+		response(w, http.StatusForbidden, newResponse, auth.ErrForbidden)
+		return
+	}
+
 	err := exercise.validateCreateExerciseRequest()
+	if err == archive.ErrArchivedExercise {
+		response(w, http.StatusConflict, newResponse, err)
+		return
+	}
 	if err != nil {
 		response(w, http.StatusBadRequest, newResponse, err)
 		return
 	}
 
-	err = exercise.createExercise()
+	err = exercise.createExercise(auth.FromRequest(r).String())
 	if err != nil {
 		response(w, http.StatusInternalServerError, newResponse, err)
 		return
 	}
 
+	version.SetETag(w, exercise.ID, exercise.Version)
 	newResponse.Exercise = exercise
+	newResponse.NewRecords = exercise.newRecords
 	response(w, http.StatusCreated, newResponse, err)
 }
@@ -0,0 +1,286 @@
+package create
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	auth "../authenticate-request"
+	calories "../exercise-calories"
+	metrics "../exercise-metrics"
+	plausibility "../exercise-plausibility"
+	export "../export-exercises"
+)
+
+var (
+	// ErrMissingHeader Error when the CSV has no header row
+	ErrMissingHeader = errors.New("Missing CSV header row")
+	// ErrUnknownColumn Error when a column of the CSV header does not map to an Exercise field
+	ErrUnknownColumn = errors.New("Unknown CSV column")
+	// ErrDuplicatedColumn Error when a column appears twice in the CSV header
+	ErrDuplicatedColumn = errors.New("Duplicated CSV column")
+	// ErrInvalidCaloriesEstimated Error when caloriesEstimated column is not a boolean
+	ErrInvalidCaloriesEstimated = errors.New("Invalid caloriesEstimated not a boolean")
+	// ErrInvalidStatus Error when status column is not a status of an exercise
+	ErrInvalidStatus = errors.New("Invalid status must be ACCEPTED, FLAGGED, APPROVED or REJECTED")
+	// ErrUnrankedImport Error when a row is of an exercise flagged or rejected, as the import can not keep it out of the ranking
+	ErrUnrankedImport = errors.New("Exercises flagged or rejected can not be imported")
+)
+
+// csvColumn sets the value of a CSV column on an exercise
+type csvColumn func(e *Exercise, value string) error
+
+func parseCSVInt(name string, value string) (int64, error) {
+	if value == "" {
+		return 0, nil
+	}
+
+	parsed, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid %s not an integer", name)
+	}
+
+	return parsed, nil
+}
+
+// parseCSVJSON decodes a JSON column into the value, an empty one meaning none
+func parseCSVJSON(name string, value string, decoded interface{}) error {
+	if value == "" {
+		return nil
+	}
+
+	if err := json.Unmarshal([]byte(value), decoded); err != nil {
+		return fmt.Errorf("Invalid %s not JSON", name)
+	}
+
+	return nil
+}
+
+// csvColumns columns of the export, the id is ignored as the import always creates new exercises and the status and flag reason are checked again
+var csvColumns = map[string]csvColumn{
+	"id": func(e *Exercise, value string) error {
+		return nil
+	},
+	"userid": func(e *Exercise, value string) (err error) {
+		e.UserID, err = parseCSVInt("userId", value)
+		return err
+	},
+	"description": func(e *Exercise, value string) error {
+		e.Description = export.UnescapeFormula(value)
+		return nil
+	},
+	"type": func(e *Exercise, value string) error {
+		e.ExerciseType = ExerciseType(value)
+		return nil
+	},
+	"starttime": func(e *Exercise, value string) error {
+		if value == "" {
+			return nil
+		}
+
+		startTime, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			return ErrInvalidStartTime
+		}
+
+		e.StartTime = startTime
+		return nil
+	},
+	"duration": func(e *Exercise, value string) (err error) {
+		e.Duration, err = parseCSVInt("duration", value)
+		return err
+	},
+	"calories": func(e *Exercise, value string) (err error) {
+		e.Calories, err = parseCSVInt("calories", value)
+		return err
+	},
+	"distance": func(e *Exercise, value string) error {
+		if value == "" {
+			return nil
+		}
+
+		distance, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return metrics.ErrInvalidDistance
+		}
+
+		e.Distance = distance
+		return nil
+	},
+	"intensity": func(e *Exercise, value string) error {
+		e.Intensity = calories.Intensity(value)
+		return nil
+	},
+	"timezone": func(e *Exercise, value string) error {
+		e.Timezone = value
+		return nil
+	},
+	"notes": func(e *Exercise, value string) error {
+		e.Notes = export.UnescapeFormula(value)
+		return nil
+	},
+	"tags": func(e *Exercise, value string) error {
+		e.Tags = strings.Fields(value)
+		for index, tag := range e.Tags {
+			e.Tags[index] = export.UnescapeFormula(tag)
+		}
+
+		return nil
+	},
+	"sets": func(e *Exercise, value string) error {
+		return parseCSVJSON("sets", value, &e.Sets)
+	},
+	"circuit": func(e *Exercise, value string) error {
+		return parseCSVJSON("circuit", value, &e.Circuit)
+	},
+	"swim": func(e *Exercise, value string) error {
+		return parseCSVJSON("swim", value, &e.Swim)
+	},
+	"heartrate": func(e *Exercise, value string) error {
+		return parseCSVJSON("heartRate", value, &e.heartRateSamples)
+	},
+	"status": func(e *Exercise, value string) error {
+		switch plausibility.Status(value) {
+		case "", plausibility.AcceptedStatus, plausibility.ApprovedStatus:
+			return nil
+		case plausibility.FlaggedStatus, plausibility.RejectedStatus:
+			return ErrUnrankedImport
+		}
+
+		return ErrInvalidStatus
+	},
+	"flagreason": func(e *Exercise, value string) error {
+		return nil
+	},
+	"caloriesestimated": func(e *Exercise, value string) error {
+		if value == "" {
+			return nil
+		}
+
+		estimated, err := strconv.ParseBool(value)
+		if err != nil {
+			return ErrInvalidCaloriesEstimated
+		}
+
+		e.keepEstimate = estimated
+		return nil
+	},
+}
+
+func readCSVHeader(reader *csv.Reader) ([]csvColumn, error) {
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, ErrMissingHeader
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	columns := []csvColumn{}
+	seen := map[string]bool{}
+	for _, name := range header {
+		key := strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+
+		column, ok := csvColumns[key]
+		if !ok {
+			return nil, fmt.Errorf("%s %s", ErrUnknownColumn, name)
+		}
+
+		if seen[key] {
+			return nil, fmt.Errorf("%s %s", ErrDuplicatedColumn, name)
+		}
+
+		seen[key] = true
+		columns = append(columns, column)
+	}
+
+	return columns, nil
+}
+
+// decodeCSV reads a row per exercise, rows that can not be read become failed items
+func decodeCSV(body io.Reader) ([]*batchItem, []int, error) {
+	reader := csv.NewReader(body)
+
+	columns, err := readCSVHeader(reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	items := []*batchItem{}
+	lines := []int{}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+
+		if len(items) == maxBatchSize {
+			return nil, nil, ErrBatchTooLarge
+		}
+
+		item := &batchItem{exercise: &Exercise{}}
+		line, _ := reader.FieldPos(0)
+
+		if parseErr, ok := err.(*csv.ParseError); ok {
+			item.err = parseErr.Err
+			line = parseErr.StartLine
+		} else if err != nil {
+			return nil, nil, err
+		}
+
+		for index, value := range record {
+			if item.err != nil {
+				break
+			}
+
+			item.err = columns[index](item.exercise, value)
+		}
+
+		items = append(items, item)
+		lines = append(lines, line)
+	}
+
+	if len(items) == 0 {
+		return nil, nil, ErrEmptyBatch
+	}
+
+	return items, lines, nil
+}
+
+// ImportEndpoint function that creates the exercises of the rows of a CSV with the columns of the export
+func ImportEndpoint(w http.ResponseWriter, r *http.Request) {
+	newResponse := &Response{}
+
+	defer r.Body.Close()
+
+	mode, err := getBatchMode(r)
+	if err != nil {
+		response(w, http.StatusBadRequest, newResponse, err)
+		return
+	}
+
+	items, lines, err := decodeCSV(r.Body)
+	if err != nil {
+		response(w, http.StatusBadRequest, newResponse, err)
+		return
+	}
+
+	results, status, err := processBatch(items, mode, auth.FromRequest(r))
+	if err != nil {
+		response(w, status, newResponse, err)
+		return
+	}
+
+	for index, result := range results {
+		result.Line = lines[index]
+	}
+
+	newResponse.Results = results
+	response(w, status, newResponse, err)
+}
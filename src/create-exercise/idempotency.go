@@ -0,0 +1,197 @@
+package create
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"time"
+
+	auth "../authenticate-request"
+)
+
+var (
+	// ErrIdempotencyKeyReused Error when an Idempotency-Key is sent again with a different body
+	ErrIdempotencyKeyReused = errors.New("The Idempotency-Key was already used with a different request body")
+	// ErrIdempotencyKeyInProgress Error when the first request with the Idempotency-Key has not finished
+	ErrIdempotencyKeyInProgress = errors.New("A request with the same Idempotency-Key is still being processed")
+	// ErrInvalidIdempotencyKey Error when the Idempotency-Key header is too long
+	ErrInvalidIdempotencyKey = errors.New("Invalid Idempotency-Key must have at most 255 characters")
+	// ErrRequestTooLarge Error when the body of a request with Idempotency-Key is larger than an upload
+	ErrRequestTooLarge = errors.New("The request body must not be larger than 32MB")
+)
+
+const (
+	// IdempotencyKeyHeader header carrying the key retries are identified with
+	IdempotencyKeyHeader = "Idempotency-Key"
+
+	idempotencyKeyTTL       = 24 * time.Hour
+	maxIdempotencyKeyLength = 255
+)
+
+// storedResponse first response given to an Idempotency-Key, status 0 while in progress
+type storedResponse struct {
+	requestHash string
+	status      int
+	body        []byte
+	etag        string
+}
+
+// recorder captures the response to store it along the Idempotency-Key
+type recorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *recorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *recorder) Write(body []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+
+	rec.body.Write(body)
+	return rec.ResponseWriter.Write(body)
+}
+
+// idempotencyScope keys are unique per principal and endpoint, stored on the PRINCIPAL column
+func idempotencyScope(r *http.Request) string {
+	return fmt.Sprintf("%s %s %s", auth.FromRequest(r).String(), r.Method, r.URL.Path)
+}
+
+// reserveIdempotencyKey stores the key as in progress, returning the response already stored for it if any
+func reserveIdempotencyKey(database *sql.DB, key string, scope string, requestHash string) (*storedResponse, error) {
+	now := time.Now().UTC()
+
+	_, err := database.Exec(`DELETE FROM idempotency_keys WHERE CREATED_AT < $1`, now.Add(-idempotencyKeyTTL))
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := database.Exec(`INSERT OR IGNORE INTO idempotency_keys (KEY, PRINCIPAL, REQUEST_HASH, STATUS, BODY, ETAG, CREATED_AT) VALUES ($1, $2, $3, 0, '', '', $4)`, key, scope, requestHash, now)
+	if err != nil {
+		return nil, err
+	}
+
+	reserved, err := result.RowsAffected()
+	if err != nil || reserved == 1 {
+		return nil, err
+	}
+
+	stored := &storedResponse{}
+	var body string
+	err = database.QueryRow(`SELECT REQUEST_HASH, STATUS, BODY, ETAG FROM idempotency_keys WHERE KEY=$1 AND PRINCIPAL=$2`, key, scope).Scan(&stored.requestHash, &stored.status, &body, &stored.etag)
+	stored.body = []byte(body)
+
+	return stored, err
+}
+
+// releaseIdempotencyKey forgets the key so that the next request with it is processed
+func releaseIdempotencyKey(database *sql.DB, key string, scope string) error {
+	_, err := database.Exec(`DELETE FROM idempotency_keys WHERE KEY=$1 AND PRINCIPAL=$2`, key, scope)
+	return err
+}
+
+func saveIdempotentResponse(database *sql.DB, key string, scope string, rec *recorder) error {
+	// server errors are not stored so the retry gets another chance
+	if rec.status >= http.StatusInternalServerError {
+		return releaseIdempotencyKey(database, key, scope)
+	}
+
+	_, err := database.Exec(`UPDATE idempotency_keys SET STATUS=$1, BODY=$2, ETAG=$3 WHERE KEY=$4 AND PRINCIPAL=$5`, rec.status, rec.body.String(), rec.Header().Get("ETag"), key, scope)
+
+	return err
+}
+
+func replay(w http.ResponseWriter, stored *storedResponse) {
+	if stored.etag != "" {
+		w.Header().Set("ETag", stored.etag)
+	}
+
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(stored.status)
+	w.Write(stored.body)
+}
+
+// Idempotent replays the first response given to an Idempotency-Key of the same principal and endpoint for 24 hours
+func Idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		newResponse := &Response{}
+		key := r.Header.Get(IdempotencyKeyHeader)
+		if key == "" {
+			next(w, r)
+			return
+		}
+
+		if len(key) > maxIdempotencyKeyLength {
+			response(w, http.StatusBadRequest, newResponse, ErrInvalidIdempotencyKey)
+			return
+		}
+
+		// the body is read whole to be hashed, no endpoint takes a larger one than an upload
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxUploadSize))
+		r.Body.Close()
+		if _, tooLarge := err.(*http.MaxBytesError); tooLarge {
+			response(w, http.StatusRequestEntityTooLarge, newResponse, ErrRequestTooLarge)
+			return
+		}
+		if err != nil {
+			response(w, http.StatusBadRequest, newResponse, err)
+			return
+		}
+
+		hash := sha256.Sum256(body)
+		requestHash := hex.EncodeToString(hash[:])
+		scope := idempotencyScope(r)
+
+		database, err := openDatabase()
+		if err != nil {
+			response(w, http.StatusInternalServerError, newResponse, err)
+			return
+		}
+
+		stored, err := reserveIdempotencyKey(database, key, scope, requestHash)
+		if err != nil {
+			response(w, http.StatusInternalServerError, newResponse, err)
+			return
+		}
+
+		if stored != nil {
+			if stored.requestHash != requestHash {
+				response(w, http.StatusUnprocessableEntity, newResponse, ErrIdempotencyKeyReused)
+				return
+			}
+
+			if stored.status == 0 {
+				response(w, http.StatusConflict, newResponse, ErrIdempotencyKeyInProgress)
+				return
+			}
+
+			replay(w, stored)
+			return
+		}
+
+		rec := &recorder{ResponseWriter: w}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		next(rec, r)
+
+		// a key left in progress would answer every retry with a conflict
+		if err = saveIdempotentResponse(database, key, scope, rec); err != nil {
+			log.Printf("saving the response to Idempotency-Key %s failed: %v", key, err)
+
+			if err = releaseIdempotencyKey(database, key, scope); err != nil {
+				log.Printf("releasing Idempotency-Key %s failed: %v", key, err)
+			}
+		}
+	}
+}
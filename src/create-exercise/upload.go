@@ -0,0 +1,213 @@
+package create
+
+import (
+	"database/sql"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	auth "../authenticate-request"
+	archive "../exercise-archive"
+	calories "../exercise-calories"
+	heartrate "../exercise-heartrate"
+	laps "../exercise-laps"
+	metrics "../exercise-metrics"
+	text "../exercise-text"
+	version "../exercise-version"
+	workout "../parse-workout"
+)
+
+var (
+	// ErrMissingFile Error when the upload has no file field
+	ErrMissingFile = errors.New("Missing file")
+	// ErrInvalidUserID Error when userId form field is not a number
+	ErrInvalidUserID = errors.New("Invalid userId")
+	// ErrInvalidCalories Error when calories form field is not a number
+	ErrInvalidCalories = errors.New("Invalid calories")
+)
+
+const (
+	maxUploadSize   = 32 << 20
+	maxUploadMemory = 8 << 20
+
+	defaultUploadDescription = "Imported workout"
+)
+
+// fromWorkout exercise of a recorded workout, the form fields take precedence over the recorded values
+func fromWorkout(w *workout.Workout, r *http.Request) (*Exercise, error) {
+	exercise := &Exercise{
+		Description:  r.FormValue("description"),
+		ExerciseType: ExerciseType(r.FormValue("type")),
+		StartTime:    w.StartTime,
+		Duration:     w.Duration,
+		Calories:     w.Calories,
+		Intensity:    calories.Intensity(r.FormValue("intensity")),
+	}
+
+	userID, err := strconv.ParseInt(r.FormValue("userId"), 10, 64)
+	if err != nil {
+		return nil, ErrInvalidUserID
+	}
+	exercise.UserID = userID
+
+	if r.FormValue("calories") != "" {
+		if exercise.Calories, err = strconv.ParseInt(r.FormValue("calories"), 10, 64); err != nil {
+			return nil, ErrInvalidCalories
+		}
+	}
+
+	if exercise.ExerciseType == "" {
+		exercise.ExerciseType = ExerciseType(w.ExerciseType)
+	}
+
+	// positions recorded while lifting or on a circuit are no distance to rank
+	if metrics.TakesDistance(string(exercise.ExerciseType)) {
+		exercise.Distance = math.Round(w.Distance*100) / 100
+	}
+
+	if exercise.Description == "" {
+		exercise.Description = defaultUploadDescription
+		if description, err := text.Description(w.Name); err == nil {
+			exercise.Description = description
+		}
+	}
+
+	return exercise, nil
+}
+
+func insertSamples(tx *sql.Tx, exerciseID int64, samples []*workout.Sample) error {
+	statement, err := tx.Prepare("INSERT INTO exercise_samples (EXERCISE_ID, TIME, LATITUDE, LONGITUDE, ALTITUDE, DISTANCE, HEART_RATE) VALUES (?, ?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	defer statement.Close()
+
+	for _, sample := range samples {
+		var latitude, longitude, heartRate interface{}
+		if sample.HasPosition() {
+			latitude, longitude = sample.Latitude, sample.Longitude
+		}
+
+		if sample.HeartRate > 0 {
+			heartRate = sample.HeartRate
+		}
+
+		if _, err := statement.Exec(exerciseID, sample.Time, latitude, longitude, sample.Altitude, sample.Distance, heartRate); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// heartRateSamples of the recorded workout, keeping the first valid one of every second
+func heartRateSamples(recorded *workout.Workout) []*heartrate.Sample {
+	samples := []*heartrate.Sample{}
+	for _, sample := range recorded.Samples {
+		if !heartrate.ValidBPM(sample.HeartRate) {
+			continue
+		}
+
+		if len(samples) > 0 && sample.Time.Unix() <= samples[len(samples)-1].Time.Unix() {
+			continue
+		}
+
+		samples = append(samples, &heartrate.Sample{Time: sample.Time.Truncate(time.Second), BPM: sample.HeartRate})
+	}
+
+	return samples
+}
+
+// createFromWorkout creates the exercise along the raw samples of the recorded workout
+func (e *Exercise) createFromWorkout(recorded *workout.Workout, actor string) error {
+	database, err := openDatabase()
+	if err != nil {
+		return err
+	}
+
+	tx, err := database.Begin()
+	if err != nil {
+		return err
+	}
+
+	// the heart rate is left out when its samples could not be attached to the exercise either
+	samples := heartRateSamples(recorded)
+	if heartrate.Validate(e.StartTime, e.Duration, samples) == nil {
+		e.heartRateSamples = samples
+	}
+
+	if err = e.insertExercise(tx, actor); err == nil {
+		err = insertSamples(tx, e.ID, recorded.Samples)
+	}
+	if err == nil {
+		err = laps.Save(tx, e.ID, recorded.Laps)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	e.Laps = recorded.Laps
+
+	return tx.Commit()
+}
+
+// UploadEndpoint function that creates an exercise from a GPX, TCX or FIT file sent as multipart form
+func UploadEndpoint(w http.ResponseWriter, r *http.Request) {
+	newResponse := &Response{}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
+	defer r.Body.Close()
+
+	if err := r.ParseMultipartForm(maxUploadMemory); err != nil {
+		response(w, http.StatusBadRequest, newResponse, err)
+		return
+	}
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		response(w, http.StatusBadRequest, newResponse, ErrMissingFile)
+		return
+	}
+	defer file.Close()
+
+	recorded, err := workout.Parse(header.Filename, file)
+	if err != nil {
+		response(w, http.StatusBadRequest, newResponse, err)
+		return
+	}
+
+	exercise, err := fromWorkout(recorded, r)
+	if err != nil {
+		response(w, http.StatusBadRequest, newResponse, err)
+		return
+	}
+
+	if !auth.FromRequest(r).CanActFor(exercise.UserID) {
+		response(w, http.StatusForbidden, newResponse, auth.ErrForbidden)
+		return
+	}
+
+	err = exercise.validateCreateExerciseRequest()
+	if err == archive.ErrArchivedExercise {
+		response(w, http.StatusConflict, newResponse, err)
+		return
+	}
+	if err != nil {
+		response(w, http.StatusBadRequest, newResponse, err)
+		return
+	}
+
+	if err = exercise.createFromWorkout(recorded, auth.FromRequest(r).String()); err != nil {
+		response(w, http.StatusInternalServerError, newResponse, err)
+		return
+	}
+
+	version.SetETag(w, exercise.ID, exercise.Version)
+	newResponse.Exercise = exercise
+	newResponse.NewRecords = exercise.newRecords
+	newResponse.Workout = recorded
+	response(w, http.StatusCreated, newResponse, err)
+}
@@ -0,0 +1,182 @@
+package remove
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+
+	auth "../authenticate-request"
+	archive "../exercise-archive"
+	history "../exercise-history"
+	records "../exercise-records"
+	version "../exercise-version"
+	"github.com/gorilla/mux"
+)
+
+var (
+	// ErrInvalidID Error when ID field is not valid
+	ErrInvalidID = errors.New("Invalid exercise id")
+	// ErrNoExerciseFound The exercise you tried to delete does not exists
+	ErrNoExerciseFound = errors.New("The exercise you tried to delete does not exists")
+)
+
+// children tables holding rows of an exercise, deleted along with it
+var children = []string{
+	"exercise_sets",
+	"exercise_circuits",
+	"circuit_stations",
+	"exercise_swims",
+	"swim_strokes",
+	"exercise_tags",
+	"exercise_heart_rates",
+	"exercise_samples",
+	"exercise_laps",
+	"exercise_reports",
+}
+
+// Queryer database or transaction the exercise is deleted from
+type Queryer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// Response for /exercise/{exerciseId}
+type Response struct {
+	Error string `json:"error,omitempty"`
+}
+
+func openDatabase() (*sql.DB, error) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+
+	return sql.Open("sqlite3", fmt.Sprintf("%s/egym.db", dir))
+}
+
+// DeleteChildren removes the rows the other tables hold for an exercise
+func DeleteChildren(database Queryer, exerciseID int64) error {
+	for _, table := range children {
+		if _, err := database.Exec(fmt.Sprintf(`DELETE FROM %s WHERE EXERCISE_ID=$1`, table), exerciseID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func deleteExercise(database *sql.DB, ID int64, expectedVersion int64, actor string) error {
+	tx, err := database.Begin()
+	if err != nil {
+		return err
+	}
+
+	before, err := history.Load(tx, ID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	result, err := tx.Exec(`DELETE FROM exercises WHERE ID=$1 AND VERSION=$2`, ID, expectedVersion)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	// another request modified the exercise after its version was checked
+	deleted, err := result.RowsAffected()
+	if err == nil && deleted == 0 {
+		err = version.ErrPreconditionFailed
+	}
+	if err == nil {
+		err = DeleteChildren(tx, ID)
+	}
+	if err == nil {
+		err = history.Record(tx, ID, history.DeleteAction, actor, before, nil)
+	}
+
+	// the records the exercise held go back to the next best ones
+	if err == nil {
+		_, err = records.Detect(tx, before.UserID, before.ExerciseType, 0)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func response(w http.ResponseWriter, httpStatus int, response *Response, err error) {
+	if err != nil {
+		response.Error = err.Error()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(httpStatus)
+	json.NewEncoder(w).Encode(response)
+}
+
+// ExerciseEndpoint function that handles request and response
+func ExerciseEndpoint(w http.ResponseWriter, r *http.Request) {
+	newResponse := &Response{}
+	params := mux.Vars(r)
+
+	exerciseID, err := strconv.ParseInt(params["exerciseId"], 10, 64)
+	if err != nil {
+		response(w, http.StatusBadRequest, newResponse, ErrInvalidID)
+		return
+	}
+
+	database, err := openDatabase()
+	if err != nil {
+		response(w, http.StatusInternalServerError, newResponse, err)
+		return
+	}
+
+	stored, err := history.Load(database, exerciseID)
+	if err != nil {
+		response(w, http.StatusInternalServerError, newResponse, err)
+		return
+	}
+
+	if stored == nil {
+		response(w, http.StatusNotFound, newResponse, ErrNoExerciseFound)
+		return
+	}
+
+	if !auth.FromRequest(r).CanActFor(stored.UserID) {
+		response(w, http.StatusForbidden, newResponse, auth.ErrForbidden)
+		return
+	}
+
+	if err = version.CheckIfMatch(r, exerciseID, stored.Version); err != nil {
+		response(w, version.Status(err), newResponse, err)
+		return
+	}
+
+	err = archive.Check(database, exerciseID)
+	if err == archive.ErrArchivedExercise {
+		response(w, http.StatusConflict, newResponse, err)
+		return
+	}
+	if err != nil {
+		response(w, http.StatusInternalServerError, newResponse, err)
+		return
+	}
+
+	err = deleteExercise(database, exerciseID, stored.Version, auth.FromRequest(r).String())
+	if err == version.ErrPreconditionFailed {
+		response(w, http.StatusPreconditionFailed, newResponse, err)
+		return
+	}
+	if err != nil {
+		response(w, http.StatusInternalServerError, newResponse, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,47 @@
+package archive
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var (
+	// ErrArchivedExercise The exercise belongs to a season whose ranking is already archived
+	ErrArchivedExercise = errors.New("The exercise belongs to an archived season and can not be modified")
+)
+
+// Queryer database or transaction the archived seasons are read from
+type Queryer interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// CheckStartTime verifies an exercise starting at the time does not belong to an archived season
+func CheckStartTime(database Queryer, startTime time.Time) error {
+	var totalArchivedSeasons int
+	err := database.QueryRow(`SELECT COUNT(*) FROM seasons WHERE $1 BETWEEN START_DATE AND END_DATE`, startTime.UTC()).Scan(&totalArchivedSeasons)
+	if err != nil {
+		return err
+	}
+
+	if totalArchivedSeasons > 0 {
+		return ErrArchivedExercise
+	}
+
+	return nil
+}
+
+// Check verifies the stored exercise does not belong to an archived season, nothing to check when it does not exists
+func Check(database Queryer, exerciseID int64) error {
+	var totalArchivedSeasons int
+	err := database.QueryRow(`SELECT COUNT(*) FROM seasons WHERE (SELECT START_TIME FROM exercises WHERE ID=$1) BETWEEN START_DATE AND END_DATE`, exerciseID).Scan(&totalArchivedSeasons)
+	if err != nil {
+		return err
+	}
+
+	if totalArchivedSeasons > 0 {
+		return ErrArchivedExercise
+	}
+
+	return nil
+}
@@ -0,0 +1,113 @@
+package calories
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Intensity effort the exercise was done with
+type Intensity string
+
+var (
+	// ErrInvalidIntensity Error when intensity field is invalid
+	ErrInvalidIntensity = errors.New("Invalid intensity must be LOW, MODERATE or HIGH")
+	// ErrNotEstimable Error when calories are not received and the type has no MET to estimate them
+	ErrNotEstimable = errors.New("Missing calories, they can not be estimated for the type of the exercise")
+)
+
+const (
+	// LowIntensity light effort, conversation is easy
+	LowIntensity Intensity = "LOW"
+	// ModerateIntensity noticeable effort, the default when not received
+	ModerateIntensity Intensity = "MODERATE"
+	// HighIntensity hard effort, conversation is not possible
+	HighIntensity Intensity = "HIGH"
+
+	// defaultWeight kilograms used when the user did not set their weight
+	defaultWeight = 70.0
+)
+
+// metColumns columns of exercise_types with the metabolic equivalent of the type at every intensity
+var metColumns = map[Intensity]string{
+	LowIntensity:      "LOW_MET",
+	ModerateIntensity: "MODERATE_MET",
+	HighIntensity:     "HIGH_MET",
+}
+
+// Queryer database or transaction the weight of the user and the METs of the type are read from
+type Queryer interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// ValidateIntensity checks the intensity is known, empty meaning moderate
+func ValidateIntensity(intensity Intensity) error {
+	switch intensity {
+	case "", LowIntensity, ModerateIntensity, HighIntensity:
+		return nil
+	default:
+		return ErrInvalidIntensity
+	}
+}
+
+// MET metabolic equivalent of the type at the intensity managed through /admin, false when the type has none
+func MET(database Queryer, exerciseType string, intensity Intensity) (float64, bool, error) {
+	if intensity == "" {
+		intensity = ModerateIntensity
+	}
+
+	var met sql.NullFloat64
+	err := database.QueryRow(fmt.Sprintf(`SELECT %s FROM exercise_types WHERE TYPE=$1`, metColumns[intensity]), exerciseType).Scan(&met)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+
+	return met.Float64, met.Float64 > 0, nil
+}
+
+func weightOf(database Queryer, userID int64) (float64, error) {
+	var weight float64
+	err := database.QueryRow(`SELECT WEIGHT FROM users WHERE ID=$1`, userID).Scan(&weight)
+	if err != nil && err != sql.ErrNoRows {
+		return 0, err
+	}
+
+	if weight <= 0 {
+		return defaultWeight, nil
+	}
+
+	return weight, nil
+}
+
+// Estimate calories burnt as MET times the weight of the user in kilograms times the hours of the exercise
+func Estimate(database Queryer, userID int64, exerciseType string, intensity Intensity, duration int64) (int64, error) {
+	if err := ValidateIntensity(intensity); err != nil {
+		return 0, err
+	}
+
+	met, ok, err := MET(database, exerciseType, intensity)
+	if err != nil {
+		return 0, err
+	}
+
+	if !ok {
+		return 0, ErrNotEstimable
+	}
+
+	weight, err := weightOf(database, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	// an exercise burns at least one calorie, zero would mean missing
+	estimated := int64(math.Round(met * weight * float64(duration) / 3600))
+	if estimated < 1 {
+		estimated = 1
+	}
+
+	return estimated, nil
+}
@@ -0,0 +1,478 @@
+package heartrate
+
+import (
+	"database/sql"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	auth "../authenticate-request"
+	archive "../exercise-archive"
+	history "../exercise-history"
+	version "../exercise-version"
+	"github.com/gorilla/mux"
+)
+
+var (
+	// ErrInvalidID Error when exercise id is not valid
+	ErrInvalidID = errors.New("Invalid exercise id")
+	// ErrNoExerciseFound The exercise does not exists
+	ErrNoExerciseFound = errors.New("The exercise you requested does not exists")
+	// ErrNoHeartRateFound The exercise has no heart rate samples
+	ErrNoHeartRateFound = errors.New("The exercise has no heart rate samples")
+	// ErrMissingSamples Error when samples field is empty
+	ErrMissingSamples = errors.New("Missing samples")
+	// ErrTooManySamples Error when more samples than allowed are received
+	ErrTooManySamples = errors.New("An exercise can not have more than 86400 heart rate samples")
+	// ErrInvalidBPM Error when bpm field of a sample is out of range
+	ErrInvalidBPM = errors.New("Invalid bpm must be between 25 and 250")
+	// ErrUnorderedSamples Error when the samples are not in chronological order, one per second at most
+	ErrUnorderedSamples = errors.New("Invalid samples must be ordered by time with at most one per second")
+	// ErrSampleOutOfExercise Error when a sample was taken outside of the exercise
+	ErrSampleOutOfExercise = errors.New("Invalid samples must be taken between the start and the end of the exercise")
+	// ErrCorruptedSamples Error when the stored samples can not be decoded
+	ErrCorruptedSamples = errors.New("The stored heart rate samples are corrupted")
+)
+
+const (
+	minBPM     = 25
+	maxBPM     = 250
+	maxSamples = 86400
+
+	// defaultMaxHeartRate used when the user has neither max heart rate nor birth year
+	defaultMaxHeartRate = 190
+	// maxSampleGap seconds a sample is held at most, longer gaps are pauses of the recording
+	maxSampleGap = 30
+	zones        = 5
+)
+
+// Sample heart rate at a point in time
+type Sample struct {
+	Time time.Time `json:"time"`
+	BPM  int64     `json:"bpm"`
+}
+
+// Zone time spent between a range of percentages of the max heart rate
+type Zone struct {
+	// Zone number, from 1 at 50% of the max heart rate to 5 at 90%
+	Zone int `json:"zone"`
+	// MinBPM lowest beats per minute of the zone
+	MinBPM int64 `json:"minBpm"`
+	// MaxBPM beats per minute the next zone starts at
+	MaxBPM int64 `json:"maxBpm"`
+	// Seconds spent in the zone
+	Seconds int64 `json:"seconds"`
+}
+
+// HeartRate summary of the heart rate of an exercise
+type HeartRate struct {
+	// Average beats per minute over the samples
+	Average int64 `json:"average"`
+	// Max beats per minute reached
+	Max int64 `json:"max"`
+	// MaxHeartRate of the user the zones are based on
+	MaxHeartRate int64 `json:"maxHeartRate"`
+	// Zones time spent in every heart rate zone
+	Zones []*Zone `json:"zones"`
+	// TrainingLoad minutes in every zone times the number of the zone, Edwards TRIMP
+	TrainingLoad float64 `json:"trainingLoad"`
+	// Samples stored, only returned on /exercise/{exerciseId}/heart-rate
+	Samples []*Sample `json:"samples,omitempty"`
+}
+
+// Request structure of /exercise/{exerciseId}/heart-rate
+type Request struct {
+	Samples []*Sample `json:"samples"`
+}
+
+// Queryer database or transaction the heart rate is read from and written to
+type Queryer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// Response for /exercise/{exerciseId}/heart-rate
+type Response struct {
+	HeartRate *HeartRate `json:"heartRate,omitempty"`
+	Error     string     `json:"error,omitempty"`
+}
+
+func openDatabase() (*sql.DB, error) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+
+	return sql.Open("sqlite3", fmt.Sprintf("%s/egym.db", dir))
+}
+
+// ValidBPM whether the beats per minute are in the range a sample can have
+func ValidBPM(bpm int64) bool {
+	return bpm >= minBPM && bpm <= maxBPM
+}
+
+// Validate checks the samples were taken, in order, during the exercise
+func Validate(startTime time.Time, duration int64, samples []*Sample) error {
+	if len(samples) == 0 {
+		return ErrMissingSamples
+	}
+
+	if len(samples) > maxSamples {
+		return ErrTooManySamples
+	}
+
+	finishTime := startTime.Add(time.Duration(duration) * time.Second)
+	for index, sample := range samples {
+		if !ValidBPM(sample.BPM) {
+			return ErrInvalidBPM
+		}
+
+		if sample.Time.Before(startTime.Truncate(time.Second)) || sample.Time.After(finishTime) {
+			return ErrSampleOutOfExercise
+		}
+
+		if index > 0 && sample.Time.Unix() <= samples[index-1].Time.Unix() {
+			return ErrUnorderedSamples
+		}
+	}
+
+	return nil
+}
+
+// encode packs the samples as varints of the seconds and bpm elapsed since the previous sample
+func encode(samples []*Sample) []byte {
+	buffer := make([]byte, binary.MaxVarintLen64*(2*len(samples)+1))
+	size := binary.PutUvarint(buffer, uint64(len(samples)))
+
+	previousTime := samples[0].Time.Unix()
+	var previousBPM int64
+	for _, sample := range samples {
+		size += binary.PutUvarint(buffer[size:], uint64(sample.Time.Unix()-previousTime))
+		size += binary.PutVarint(buffer[size:], sample.BPM-previousBPM)
+
+		previousTime = sample.Time.Unix()
+		previousBPM = sample.BPM
+	}
+
+	return buffer[:size]
+}
+
+func decode(firstSample int64, data []byte) ([]*Sample, error) {
+	count, size := binary.Uvarint(data)
+	if size <= 0 || count > maxSamples {
+		return nil, ErrCorruptedSamples
+	}
+	data = data[size:]
+
+	samples := []*Sample{}
+	previousTime := firstSample
+	var previousBPM int64
+	for index := uint64(0); index < count; index++ {
+		elapsed, size := binary.Uvarint(data)
+		if size <= 0 {
+			return nil, ErrCorruptedSamples
+		}
+		data = data[size:]
+
+		delta, size := binary.Varint(data)
+		if size <= 0 {
+			return nil, ErrCorruptedSamples
+		}
+		data = data[size:]
+
+		previousTime += int64(elapsed)
+		previousBPM += delta
+		samples = append(samples, &Sample{Time: time.Unix(previousTime, 0).UTC(), BPM: previousBPM})
+	}
+
+	return samples, nil
+}
+
+// Summarize averages the samples and splits their time in zones of the max heart rate
+func Summarize(samples []*Sample, maxHeartRate int64) *HeartRate {
+	heartRate := &HeartRate{MaxHeartRate: maxHeartRate}
+	for zone := 1; zone <= zones; zone++ {
+		heartRate.Zones = append(heartRate.Zones, &Zone{
+			Zone:   zone,
+			MinBPM: int64(math.Round(float64(maxHeartRate) * float64(zone+4) / 10)),
+			MaxBPM: int64(math.Round(float64(maxHeartRate) * float64(zone+5) / 10)),
+		})
+	}
+
+	var total int64
+	for index, sample := range samples {
+		total += sample.BPM
+		if sample.BPM > heartRate.Max {
+			heartRate.Max = sample.BPM
+		}
+
+		// every sample holds until the next one
+		if index == len(samples)-1 {
+			continue
+		}
+
+		held := samples[index+1].Time.Unix() - sample.Time.Unix()
+		if held > maxSampleGap {
+			held = maxSampleGap
+		}
+
+		for _, zone := range heartRate.Zones {
+			if sample.BPM >= zone.MinBPM && (sample.BPM < zone.MaxBPM || zone.Zone == zones) {
+				zone.Seconds += held
+				heartRate.TrainingLoad += float64(held) / 60 * float64(zone.Zone)
+			}
+		}
+	}
+
+	if len(samples) > 0 {
+		heartRate.Average = int64(math.Round(float64(total) / float64(len(samples))))
+	}
+	heartRate.TrainingLoad = math.Round(heartRate.TrainingLoad*10) / 10
+
+	return heartRate
+}
+
+// maxHeartRateOf the user, set by them or estimated as 220 minus their age at the time of the exercise
+func maxHeartRateOf(database Queryer, userID int64, at time.Time) (int64, error) {
+	var maxHeartRate, birthYear int64
+	err := database.QueryRow(`SELECT MAX_HEART_RATE, BIRTH_YEAR FROM users WHERE ID=$1`, userID).Scan(&maxHeartRate, &birthYear)
+	if err != nil && err != sql.ErrNoRows {
+		return 0, err
+	}
+
+	switch {
+	case maxHeartRate > 0:
+		return maxHeartRate, nil
+	case birthYear > 0:
+		return 220 - (int64(at.Year()) - birthYear), nil
+	default:
+		return defaultMaxHeartRate, nil
+	}
+}
+
+// Save stores the samples of an exercise compactly along their training load, replacing the previous ones
+func Save(database Queryer, exerciseID int64, userID int64, samples []*Sample) (*HeartRate, error) {
+	maxHeartRate, err := maxHeartRateOf(database, userID, samples[0].Time)
+	if err != nil {
+		return nil, err
+	}
+
+	heartRate := Summarize(samples, maxHeartRate)
+
+	sqlStatement := `INSERT OR REPLACE INTO exercise_heart_rates (EXERCISE_ID, FIRST_SAMPLE, SAMPLES, MAX_HEART_RATE, TRAINING_LOAD) VALUES ($1, $2, $3, $4, $5)`
+	_, err = database.Exec(sqlStatement, exerciseID, samples[0].Time.Unix(), encode(samples), maxHeartRate, heartRate.TrainingLoad)
+	if err != nil {
+		return nil, err
+	}
+
+	return heartRate, nil
+}
+
+// Load summary of the heart rate of an exercise, nil when it has no samples
+func Load(database Queryer, exerciseID int64, withSamples bool) (*HeartRate, error) {
+	var firstSample, maxHeartRate int64
+	var data []byte
+
+	err := database.QueryRow(`SELECT FIRST_SAMPLE, SAMPLES, MAX_HEART_RATE FROM exercise_heart_rates WHERE EXERCISE_ID=$1`, exerciseID).Scan(&firstSample, &data, &maxHeartRate)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	samples, err := decode(firstSample, data)
+	if err != nil {
+		return nil, err
+	}
+
+	heartRate := Summarize(samples, maxHeartRate)
+	if withSamples {
+		heartRate.Samples = samples
+	}
+
+	return heartRate, nil
+}
+
+func response(w http.ResponseWriter, httpStatus int, response *Response, err error) {
+	if err != nil {
+		response.Error = err.Error()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(httpStatus)
+	json.NewEncoder(w).Encode(response)
+}
+
+// exercise owner, start time, duration and version of an exercise
+type exercise struct {
+	userID    int64
+	startTime time.Time
+	duration  int64
+	version   int64
+}
+
+func getExercise(database Queryer, exerciseID int64) (*exercise, error) {
+	e := &exercise{}
+	err := database.QueryRow(`SELECT USER_ID, START_TIME, DURATION, VERSION FROM exercises WHERE ID=$1`, exerciseID).Scan(&e.userID, &e.startTime, &e.duration, &e.version)
+	if err == sql.ErrNoRows {
+		return nil, ErrNoExerciseFound
+	}
+
+	return e, err
+}
+
+// attach saves the samples and issues a new version of the exercise recorded in its history, as its representation includes the heart rate
+func attach(database *sql.DB, exerciseID int64, userID int64, expectedVersion int64, samples []*Sample, actor string) (*HeartRate, int64, error) {
+	tx, err := database.Begin()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var heartRate *HeartRate
+	var before, after *history.State
+	err = archive.Check(tx, exerciseID)
+	if err == nil {
+		before, err = history.Load(tx, exerciseID)
+	}
+	if err == nil {
+		heartRate, err = Save(tx, exerciseID, userID, samples)
+	}
+
+	var result sql.Result
+	if err == nil {
+		result, err = tx.Exec(`UPDATE exercises SET VERSION=VERSION+1 WHERE ID=$1 AND VERSION=$2`, exerciseID, expectedVersion)
+	}
+
+	// another request modified the exercise after its version was checked
+	var updated int64
+	if err == nil {
+		updated, err = result.RowsAffected()
+	}
+	if err == nil && updated == 0 {
+		err = version.ErrPreconditionFailed
+	}
+	if err == nil {
+		after, err = history.Load(tx, exerciseID)
+	}
+	if err == nil {
+		err = history.Record(tx, exerciseID, history.UpdateAction, actor, before, after)
+	}
+	if err != nil {
+		tx.Rollback()
+		return nil, 0, err
+	}
+
+	return heartRate, after.Version, tx.Commit()
+}
+
+func statusOf(err error) int {
+	switch err {
+	case ErrInvalidID:
+		return http.StatusBadRequest
+	case auth.ErrForbidden:
+		return http.StatusForbidden
+	case ErrNoExerciseFound:
+		return http.StatusNotFound
+	case archive.ErrArchivedExercise:
+		return http.StatusConflict
+	case version.ErrPreconditionRequired, version.ErrPreconditionFailed:
+		return version.Status(err)
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// authorizedExercise opens the database and checks the principal owns the exercise of the request
+func authorizedExercise(r *http.Request) (*sql.DB, int64, *exercise, error) {
+	exerciseID, err := strconv.ParseInt(mux.Vars(r)["exerciseId"], 10, 64)
+	if err != nil {
+		return nil, 0, nil, ErrInvalidID
+	}
+
+	database, err := openDatabase()
+	if err != nil {
+		return nil, 0, nil, err
+	}
+
+	stored, err := getExercise(database, exerciseID)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+
+	if !auth.FromRequest(r).CanActFor(stored.userID) {
+		return nil, 0, nil, auth.ErrForbidden
+	}
+
+	return database, exerciseID, stored, nil
+}
+
+// HeartRateEndpoint function that returns the heart rate samples of an exercise and their summary
+func HeartRateEndpoint(w http.ResponseWriter, r *http.Request) {
+	newResponse := &Response{}
+
+	database, exerciseID, _, err := authorizedExercise(r)
+	if err != nil {
+		response(w, statusOf(err), newResponse, err)
+		return
+	}
+
+	heartRate, err := Load(database, exerciseID, true)
+	if err != nil {
+		response(w, http.StatusInternalServerError, newResponse, err)
+		return
+	}
+
+	if heartRate == nil {
+		response(w, http.StatusNotFound, newResponse, ErrNoHeartRateFound)
+		return
+	}
+
+	newResponse.HeartRate = heartRate
+	response(w, http.StatusOK, newResponse, err)
+}
+
+// AttachHeartRateEndpoint function that stores the heart rate samples of an exercise, replacing previous ones
+func AttachHeartRateEndpoint(w http.ResponseWriter, r *http.Request) {
+	newResponse := &Response{}
+	request := &Request{}
+
+	defer r.Body.Close()
+
+	if err := json.NewDecoder(r.Body).Decode(request); err != nil {
+		response(w, http.StatusBadRequest, newResponse, err)
+		return
+	}
+
+	database, exerciseID, stored, err := authorizedExercise(r)
+	if err != nil {
+		response(w, statusOf(err), newResponse, err)
+		return
+	}
+
+	if err = version.CheckIfMatch(r, exerciseID, stored.version); err != nil {
+		response(w, version.Status(err), newResponse, err)
+		return
+	}
+
+	if err = Validate(stored.startTime, stored.duration, request.Samples); err != nil {
+		response(w, http.StatusBadRequest, newResponse, err)
+		return
+	}
+
+	heartRate, newVersion, err := attach(database, exerciseID, stored.userID, stored.version, request.Samples, auth.FromRequest(r).String())
+	if err != nil {
+		response(w, statusOf(err), newResponse, err)
+		return
+	}
+
+	version.SetETag(w, exerciseID, newVersion)
+	newResponse.HeartRate = heartRate
+	response(w, http.StatusOK, newResponse, err)
+}
@@ -0,0 +1,495 @@
+package history
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	auth "../authenticate-request"
+	archive "../exercise-archive"
+	plausibility "../exercise-plausibility"
+	records "../exercise-records"
+	circuits "../manage-circuits"
+	sets "../manage-sets"
+	swims "../manage-swims"
+	tags "../manage-tags"
+	"github.com/gorilla/mux"
+)
+
+// Action Action recorded on the history of an exercise
+type Action string
+
+var (
+	// ErrInvalidID Error when exercise or entry id is not valid
+	ErrInvalidID = errors.New("Invalid id")
+	// ErrNoExerciseFound The exercise has no history
+	ErrNoExerciseFound = errors.New("The exercise you requested has no history")
+	// ErrNoEntryFound The history entry does not exists
+	ErrNoEntryFound = errors.New("The history entry does not exists")
+	// ErrNotRevertable Error when the entry has no previous state to go back to
+	ErrNotRevertable = errors.New("Only updates and deletes can be reverted")
+	// ErrExerciseExists Error when reverting a delete of an exercise that exists again
+	ErrExerciseExists = errors.New("The deleted exercise has already been restored")
+	// ErrExerciseOverlapping Error when the exercise would go back to a time taken by another one
+	ErrExerciseOverlapping = errors.New("The exercise would overlap with an existing one")
+	// ErrInvalidRestoreStatus Error when restoreStatus param is not a boolean
+	ErrInvalidRestoreStatus = errors.New("Invalid restoreStatus not a boolean")
+)
+
+const (
+	// CreateAction exercise was created
+	CreateAction Action = "CREATE"
+	// UpdateAction exercise was modified
+	UpdateAction Action = "UPDATE"
+	// DeleteAction exercise was deleted
+	DeleteAction Action = "DELETE"
+	// RevertAction exercise went back to the state previous to another entry
+	RevertAction Action = "REVERT"
+)
+
+// State stored values of an exercise at a point in time
+type State struct {
+	UserID            int64     `json:"userId"`
+	Description       string    `json:"description"`
+	Notes             string    `json:"notes,omitempty"`
+	ExerciseType      string    `json:"type"`
+	StartTime         time.Time `json:"startTime"`
+	Timezone          string    `json:"timezone,omitempty"`
+	Duration          int64     `json:"duration"`
+	Calories          int64     `json:"calories"`
+	CaloriesEstimated bool      `json:"caloriesEstimated,omitempty"`
+	Intensity         string    `json:"intensity,omitempty"`
+	Distance          float64   `json:"distance,omitempty"`
+	// Status and the children below are empty on entries recorded before they were kept
+	Status     plausibility.Status `json:"status,omitempty"`
+	FlagReason string              `json:"flagReason,omitempty"`
+	Sets       []*sets.Set         `json:"sets,omitempty"`
+	Circuit    *circuits.Circuit   `json:"circuit,omitempty"`
+	Swim       *swims.Swim         `json:"swim,omitempty"`
+	Tags       []string            `json:"tags,omitempty"`
+	// TrainingLoad of the heart rate samples, attached apart and not restored by a revert
+	TrainingLoad float64 `json:"trainingLoad,omitempty"`
+	Version      int64   `json:"version"`
+}
+
+// Entry immutable record of a change of an exercise
+type Entry struct {
+	ID         int64     `json:"id"`
+	ExerciseID int64     `json:"exerciseId"`
+	Action     Action    `json:"action"`
+	Actor      string    `json:"actor"`
+	CreatedAt  time.Time `json:"createdAt"`
+	Before     *State    `json:"before,omitempty"`
+	After      *State    `json:"after,omitempty"`
+}
+
+// Queryer database or transaction the history is read from and written to
+type Queryer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// Response for /exercise/{exerciseId}/history
+type Response struct {
+	History []*Entry `json:"history,omitempty"`
+	Entry   *Entry   `json:"entry,omitempty"`
+	Error   string   `json:"error,omitempty"`
+}
+
+func openDatabase() (*sql.DB, error) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+
+	return sql.Open("sqlite3", fmt.Sprintf("%s/egym.db", dir))
+}
+
+// Load current state of an exercise with its children, nil when it does not exists
+func Load(database Queryer, exerciseID int64) (*State, error) {
+	state := &State{}
+	sqlStatement := `SELECT USER_ID, DESCRIPTION, NOTES, TYPE, START_TIME, TIMEZONE, DURATION, CALORIES, CALORIES_ESTIMATED, INTENSITY, DISTANCE, STATUS, FLAG_REASON, VERSION FROM exercises WHERE ID=$1`
+	err := database.QueryRow(sqlStatement, exerciseID).Scan(&state.UserID, &state.Description, &state.Notes, &state.ExerciseType, &state.StartTime, &state.Timezone, &state.Duration, &state.Calories, &state.CaloriesEstimated, &state.Intensity, &state.Distance, &state.Status, &state.FlagReason, &state.Version)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if state.Sets, err = sets.Load(database, exerciseID); err != nil {
+		return nil, err
+	}
+
+	if state.Circuit, err = circuits.Load(database, exerciseID); err != nil {
+		return nil, err
+	}
+
+	// the rounds and the swim metrics are derived, only what was received is kept
+	if state.Circuit != nil {
+		state.Circuit.Rounds = nil
+	}
+
+	if state.Swim, err = swims.Load(database, exerciseID, state.Duration); err != nil {
+		return nil, err
+	}
+
+	if state.Tags, err = tags.Load(database, exerciseID); err != nil {
+		return nil, err
+	}
+
+	err = database.QueryRow(`SELECT TRAINING_LOAD FROM exercise_heart_rates WHERE EXERCISE_ID=$1`, exerciseID).Scan(&state.TrainingLoad)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	return state, nil
+}
+
+// restoreChildren saves the sets, circuit, swim and tags of the state, replacing the current ones
+func restoreChildren(tx *sql.Tx, exerciseID int64, state *State) error {
+	if _, err := tx.Exec(`DELETE FROM exercise_sets WHERE EXERCISE_ID=$1`, exerciseID); err != nil {
+		return err
+	}
+
+	if err := sets.Insert(tx, exerciseID, state.Sets); err != nil {
+		return err
+	}
+
+	if err := circuits.Save(tx, exerciseID, state.Circuit); err != nil {
+		return err
+	}
+
+	if err := swims.Save(tx, exerciseID, state.Swim); err != nil {
+		return err
+	}
+
+	return tags.Save(tx, exerciseID, state.Tags)
+}
+
+// overlaps whether another exercise of the user takes place between the start and the finish time
+func overlaps(tx *sql.Tx, exerciseID int64, userID int64, startTime time.Time, finishTime time.Time) (bool, error) {
+	var totalOverlapping int
+	err := tx.QueryRow(`SELECT COUNT(*) FROM exercises WHERE ID<>$1 AND USER_ID=$2 AND START_TIME <= $3 AND FINISH_TIME >= $4`, exerciseID, userID, finishTime, startTime).Scan(&totalOverlapping)
+
+	return totalOverlapping > 0, err
+}
+
+func encodeState(state *State) (interface{}, error) {
+	if state == nil {
+		return nil, nil
+	}
+
+	encoded, err := json.Marshal(state)
+	return string(encoded), err
+}
+
+func decodeState(encoded sql.NullString) (*State, error) {
+	if !encoded.Valid {
+		return nil, nil
+	}
+
+	state := &State{}
+	return state, json.Unmarshal([]byte(encoded.String), state)
+}
+
+func record(database Queryer, exerciseID int64, action Action, actor string, before *State, after *State) (sql.Result, error) {
+	encodedBefore, err := encodeState(before)
+	if err != nil {
+		return nil, err
+	}
+
+	encodedAfter, err := encodeState(after)
+	if err != nil {
+		return nil, err
+	}
+
+	return database.Exec(`INSERT INTO exercise_audit (EXERCISE_ID, ACTION, ACTOR, CREATED_AT, BEFORE, AFTER) VALUES ($1, $2, $3, $4, $5, $6)`, exerciseID, action, actor, time.Now().UTC(), encodedBefore, encodedAfter)
+}
+
+// Record appends an entry to the history of an exercise
+func Record(database Queryer, exerciseID int64, action Action, actor string, before *State, after *State) error {
+	_, err := record(database, exerciseID, action, actor, before, after)
+	return err
+}
+
+func getOwner(database *sql.DB, exerciseID int64) (int64, error) {
+	var userID int64
+	sqlStatement := `SELECT COALESCE(json_extract(AFTER, '$.userId'), json_extract(BEFORE, '$.userId')) FROM exercise_audit WHERE EXERCISE_ID=$1 ORDER BY ID DESC LIMIT 1`
+	err := database.QueryRow(sqlStatement, exerciseID).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return 0, ErrNoExerciseFound
+	}
+
+	return userID, err
+}
+
+func scanEntry(scanner interface{ Scan(...interface{}) error }) (*Entry, error) {
+	entry := &Entry{}
+	var before, after sql.NullString
+
+	err := scanner.Scan(&entry.ID, &entry.ExerciseID, &entry.Action, &entry.Actor, &entry.CreatedAt, &before, &after)
+	if err != nil {
+		return nil, err
+	}
+
+	if entry.Before, err = decodeState(before); err != nil {
+		return nil, err
+	}
+
+	entry.After, err = decodeState(after)
+
+	return entry, err
+}
+
+func getHistory(database *sql.DB, exerciseID int64) ([]*Entry, error) {
+	result, err := database.Query(`SELECT ID, EXERCISE_ID, ACTION, ACTOR, CREATED_AT, BEFORE, AFTER FROM exercise_audit WHERE EXERCISE_ID=$1 ORDER BY ID`, exerciseID)
+	if err != nil {
+		return nil, err
+	}
+	defer result.Close()
+
+	entries := []*Entry{}
+	for result.Next() {
+		entry, err := scanEntry(result)
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, result.Err()
+}
+
+func getEntry(database *sql.DB, exerciseID int64, entryID int64) (*Entry, error) {
+	row := database.QueryRow(`SELECT ID, EXERCISE_ID, ACTION, ACTOR, CREATED_AT, BEFORE, AFTER FROM exercise_audit WHERE EXERCISE_ID=$1 AND ID=$2`, exerciseID, entryID)
+
+	entry, err := scanEntry(row)
+	if err == sql.ErrNoRows {
+		return nil, ErrNoEntryFound
+	}
+
+	return entry, err
+}
+
+// reviewed whether the status is the decision of a moderator
+func reviewed(status plausibility.Status) bool {
+	return status == plausibility.ApprovedStatus || status == plausibility.RejectedStatus
+}
+
+func revert(database *sql.DB, entry *Entry, actor string, restoreStatus bool) (*Entry, error) {
+	if entry.Before == nil || (entry.Action != UpdateAction && entry.Action != DeleteAction) {
+		return nil, ErrNotRevertable
+	}
+
+	tx, err := database.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	current, err := Load(tx, entry.ExerciseID)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	// neither the season the exercise is in nor the one it goes back to may be archived
+	err = archive.Check(tx, entry.ExerciseID)
+	if err == nil {
+		err = archive.CheckStartTime(tx, entry.Before.StartTime)
+	}
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	state := entry.Before
+	finishTime := state.StartTime.Add(time.Second * time.Duration(state.Duration))
+
+	// the state goes back through the checks of a modification, the rules may have changed since
+	overlapping, err := overlaps(tx, entry.ExerciseID, state.UserID, state.StartTime, finishTime)
+	if err == nil && overlapping {
+		err = ErrExerciseOverlapping
+	}
+
+	var flagReason string
+	if err == nil {
+		flagReason, err = plausibility.Check(tx, state.ExerciseType, state.StartTime, state.Duration, state.Calories, state.Distance)
+	}
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	// entries recorded before the status was kept leave the current status and children as they are,
+	// a review decision taken since is kept too unless the status of the entry is restored explicitly
+	legacy := state.Status == ""
+	stored := state.Status
+	if current != nil && (legacy || (reviewed(current.Status) && !restoreStatus)) {
+		stored = current.Status
+	}
+	status := plausibility.Revise(stored, flagReason)
+
+	if current == nil {
+		_, err = tx.Exec(`INSERT INTO exercises (ID, USER_ID, DESCRIPTION, NOTES, TYPE, START_TIME, FINISH_TIME, TIMEZONE, DURATION, CALORIES, CALORIES_ESTIMATED, INTENSITY, DISTANCE, STATUS, FLAG_REASON, VERSION) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)`, entry.ExerciseID, state.UserID, state.Description, state.Notes, state.ExerciseType, state.StartTime, finishTime, state.Timezone, state.Duration, state.Calories, state.CaloriesEstimated, state.Intensity, state.Distance, status, flagReason, state.Version+1)
+	} else if entry.Action == DeleteAction {
+		err = ErrExerciseExists
+	} else {
+		_, err = tx.Exec(`UPDATE exercises SET USER_ID=$1, DESCRIPTION=$2, NOTES=$3, START_TIME=$4, FINISH_TIME=$5, TIMEZONE=$6, DURATION=$7, CALORIES=$8, CALORIES_ESTIMATED=$9, INTENSITY=$10, DISTANCE=$11, STATUS=$12, FLAG_REASON=$13, VERSION=VERSION+1 WHERE ID=$14`, state.UserID, state.Description, state.Notes, state.StartTime, finishTime, state.Timezone, state.Duration, state.Calories, state.CaloriesEstimated, state.Intensity, state.Distance, status, flagReason, entry.ExerciseID)
+	}
+
+	if err == nil && !legacy {
+		err = restoreChildren(tx, entry.ExerciseID, state)
+	}
+
+	var after *State
+	if err == nil {
+		after, err = Load(tx, entry.ExerciseID)
+	}
+
+	var result sql.Result
+	if err == nil {
+		result, err = record(tx, entry.ExerciseID, RevertAction, actor, current, after)
+	}
+	if err == nil {
+		_, err = records.Detect(tx, after.UserID, after.ExerciseType, entry.ExerciseID)
+	}
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	entryID, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	return getEntry(database, entry.ExerciseID, entryID)
+}
+
+func response(w http.ResponseWriter, httpStatus int, response *Response, err error) {
+	if err != nil {
+		response.Error = err.Error()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(httpStatus)
+	json.NewEncoder(w).Encode(response)
+}
+
+// HistoryEndpoint function that returns every change recorded for an exercise
+func HistoryEndpoint(w http.ResponseWriter, r *http.Request) {
+	newResponse := &Response{}
+	params := mux.Vars(r)
+
+	exerciseID, err := strconv.ParseInt(params["exerciseId"], 10, 64)
+	if err != nil {
+		response(w, http.StatusBadRequest, newResponse, ErrInvalidID)
+		return
+	}
+
+	database, err := openDatabase()
+	if err != nil {
+		response(w, http.StatusInternalServerError, newResponse, err)
+		return
+	}
+
+	ownerID, err := getOwner(database, exerciseID)
+	if err == ErrNoExerciseFound {
+		response(w, http.StatusNotFound, newResponse, err)
+		return
+	}
+	if err != nil {
+		response(w, http.StatusInternalServerError, newResponse, err)
+		return
+	}
+
+	if !auth.FromRequest(r).CanActFor(ownerID) {
+		response(w, http.StatusForbidden, newResponse, auth.ErrForbidden)
+		return
+	}
+
+	entries, err := getHistory(database, exerciseID)
+	if err != nil {
+		response(w, http.StatusInternalServerError, newResponse, err)
+		return
+	}
+
+	newResponse.History = entries
+	response(w, http.StatusOK, newResponse, err)
+}
+
+// RevertEndpoint function that restores the exercise to the state it had before an entry, restoreStatus=true overrides a review decision
+func RevertEndpoint(w http.ResponseWriter, r *http.Request) {
+	newResponse := &Response{}
+	params := mux.Vars(r)
+
+	if !auth.FromRequest(r).HasRole(auth.AdminRole) {
+		response(w, http.StatusForbidden, newResponse, auth.ErrInsufficientRole)
+		return
+	}
+
+	exerciseID, err := strconv.ParseInt(params["exerciseId"], 10, 64)
+	if err != nil {
+		response(w, http.StatusBadRequest, newResponse, ErrInvalidID)
+		return
+	}
+
+	entryID, err := strconv.ParseInt(params["entryId"], 10, 64)
+	if err != nil {
+		response(w, http.StatusBadRequest, newResponse, ErrInvalidID)
+		return
+	}
+
+	database, err := openDatabase()
+	if err != nil {
+		response(w, http.StatusInternalServerError, newResponse, err)
+		return
+	}
+
+	entry, err := getEntry(database, exerciseID, entryID)
+	if err == ErrNoEntryFound {
+		response(w, http.StatusNotFound, newResponse, err)
+		return
+	}
+	if err != nil {
+		response(w, http.StatusInternalServerError, newResponse, err)
+		return
+	}
+
+	restoreStatus := false
+	if value := r.URL.Query().Get("restoreStatus"); value != "" {
+		if restoreStatus, err = strconv.ParseBool(value); err != nil {
+			response(w, http.StatusBadRequest, newResponse, ErrInvalidRestoreStatus)
+			return
+		}
+	}
+
+	reverted, err := revert(database, entry, auth.FromRequest(r).String(), restoreStatus)
+	switch err {
+	case ErrNotRevertable, ErrExerciseExists, ErrExerciseOverlapping, archive.ErrArchivedExercise:
+		response(w, http.StatusConflict, newResponse, err)
+		return
+	case plausibility.ErrNegativeValue, plausibility.ErrFutureStartTime, plausibility.ErrImplausibleCalories, plausibility.ErrImplausibleDuration:
+		response(w, http.StatusUnprocessableEntity, newResponse, err)
+		return
+	}
+	if err != nil {
+		response(w, http.StatusInternalServerError, newResponse, err)
+		return
+	}
+
+	newResponse.Entry = reverted
+	response(w, http.StatusOK, newResponse, err)
+}
@@ -0,0 +1,50 @@
+package laps
+
+import (
+	"database/sql"
+
+	workout "../parse-workout"
+)
+
+// Queryer database or transaction the laps are read from and written to
+type Queryer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// Save stores the laps recorded by the device for an exercise, replacing the previous ones
+func Save(database Queryer, exerciseID int64, laps []*workout.Lap) error {
+	if _, err := database.Exec(`DELETE FROM exercise_laps WHERE EXERCISE_ID=$1`, exerciseID); err != nil {
+		return err
+	}
+
+	for position, lap := range laps {
+		_, err := database.Exec(`INSERT INTO exercise_laps (EXERCISE_ID, POSITION, START_TIME, DURATION, DISTANCE, CALORIES) VALUES ($1, $2, $3, $4, $5, $6)`, exerciseID, position+1, lap.StartTime.UTC(), lap.Duration, lap.Distance, lap.Calories)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Load laps of an exercise in the order they were recorded, empty when it was not uploaded with them
+func Load(database Queryer, exerciseID int64) ([]*workout.Lap, error) {
+	result, err := database.Query(`SELECT START_TIME, DURATION, DISTANCE, CALORIES FROM exercise_laps WHERE EXERCISE_ID=$1 ORDER BY POSITION`, exerciseID)
+	if err != nil {
+		return nil, err
+	}
+	defer result.Close()
+
+	laps := []*workout.Lap{}
+	for result.Next() {
+		lap := &workout.Lap{}
+		if err := result.Scan(&lap.StartTime, &lap.Duration, &lap.Distance, &lap.Calories); err != nil {
+			return nil, err
+		}
+
+		laps = append(laps, lap)
+	}
+
+	return laps, result.Err()
+}
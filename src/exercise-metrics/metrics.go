@@ -0,0 +1,81 @@
+package metrics
+
+import (
+	"errors"
+	"math"
+)
+
+var (
+	// ErrInvalidDistance Error when distance field is negative
+	ErrInvalidDistance = errors.New("Invalid distance must be a positive number of meters")
+	// ErrUnwantedDistance Error when distance field is received for a type measured without distance
+	ErrUnwantedDistance = errors.New("Unwanted distance field received for the type of the exercise")
+	// ErrImplausibleDistance Error when the distance can not be covered in the duration of the exercise
+	ErrImplausibleDistance = errors.New("Invalid distance too long for the duration of the exercise")
+)
+
+// distanceType how the exercises of a type are measured over distance
+type distanceType struct {
+	// maxSpeed meters per second no one goes faster than
+	maxSpeed float64
+	// paceDistance meters the pace is given for
+	paceDistance int64
+}
+
+// distanceTypes exercise types that take a distance
+var distanceTypes = map[string]distanceType{
+	"RUNNING":  {maxSpeed: 12.5, paceDistance: 1000},
+	"SWIMMING": {maxSpeed: 2.5, paceDistance: 100},
+}
+
+// Metrics values derived from the distance and duration of an exercise
+type Metrics struct {
+	// Speed kilometers per hour
+	Speed float64 `json:"speed"`
+	// Pace seconds per pace distance, per kilometer running and per 100 meters swimming
+	Pace int64 `json:"pace"`
+	// PaceDistance meters the pace is given for
+	PaceDistance int64 `json:"paceDistance"`
+}
+
+// TakesDistance whether the exercises of the type can have a distance
+func TakesDistance(exerciseType string) bool {
+	_, ok := distanceTypes[exerciseType]
+	return ok
+}
+
+// ValidateDistance checks the distance, in meters, is plausible for the type and duration of the exercise
+func ValidateDistance(exerciseType string, duration int64, distance float64) error {
+	if distance < 0 {
+		return ErrInvalidDistance
+	}
+
+	if distance == 0 {
+		return nil
+	}
+
+	measure, ok := distanceTypes[exerciseType]
+	if !ok {
+		return ErrUnwantedDistance
+	}
+
+	if duration > 0 && distance/float64(duration) > measure.maxSpeed {
+		return ErrImplausibleDistance
+	}
+
+	return nil
+}
+
+// Calculate speed and pace of an exercise, nil when it has no distance
+func Calculate(exerciseType string, duration int64, distance float64) *Metrics {
+	measure, ok := distanceTypes[exerciseType]
+	if !ok || distance <= 0 || duration <= 0 {
+		return nil
+	}
+
+	return &Metrics{
+		Speed:        math.Round(distance/float64(duration)*3.6*100) / 100,
+		Pace:         int64(math.Round(float64(duration) * float64(measure.paceDistance) / distance)),
+		PaceDistance: measure.paceDistance,
+	}
+}
@@ -0,0 +1,136 @@
+package plausibility
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Mode how the exercises breaking the rules of their type are handled
+type Mode string
+
+// Status whether an exercise is ranked
+type Status string
+
+var (
+	// ErrNegativeValue Error when duration, calories or distance fields are negative
+	ErrNegativeValue = errors.New("Invalid duration, calories and distance must not be negative")
+	// ErrFutureStartTime Error when startTime field is in the future
+	ErrFutureStartTime = errors.New("Invalid startTime must not be in the future")
+	// ErrImplausibleCalories Error when more calories per minute than allowed for the type are received
+	ErrImplausibleCalories = errors.New("Invalid calories too many for the duration of the exercise")
+	// ErrImplausibleDuration Error when the duration is longer than allowed for the type
+	ErrImplausibleDuration = errors.New("Invalid duration too long for the type of the exercise")
+)
+
+const (
+	// RejectMode exercises breaking the rules are not saved
+	RejectMode Mode = "REJECT"
+	// FlagMode exercises breaking the rules are saved but kept out of the ranking until reviewed
+	FlagMode Mode = "FLAG"
+
+	// AcceptedStatus the exercise is ranked
+	AcceptedStatus Status = "ACCEPTED"
+	// FlaggedStatus the exercise is suspicious and waits for review out of the ranking
+	FlaggedStatus Status = "FLAGGED"
+	// ApprovedStatus the exercise was reviewed and is ranked
+	ApprovedStatus Status = "APPROVED"
+	// RejectedStatus the exercise was reviewed and is not ranked
+	RejectedStatus Status = "REJECTED"
+
+	// DefaultMaxCaloriesPerMinute above what the fittest athletes burn on a sustained effort
+	DefaultMaxCaloriesPerMinute = 25.0
+	// DefaultMaxDuration seconds of an ultra endurance day
+	DefaultMaxDuration = 86400
+	// DefaultMode flags instead of rejecting so no genuine effort is lost
+	DefaultMode = FlagMode
+
+	// clockSkew the start time may be ahead of the server clock
+	clockSkew = 5 * time.Minute
+)
+
+// Rules limits of the exercises of a type, zero meaning no limit
+type Rules struct {
+	MaxCaloriesPerMinute float64
+	MaxDuration          int64
+	Mode                 Mode
+}
+
+// Queryer database or transaction the rules are read from
+type Queryer interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// ValidMode whether the mode is known
+func ValidMode(mode Mode) bool {
+	return mode == RejectMode || mode == FlagMode
+}
+
+// LoadRules of a type managed through /admin, the defaults when the type does not exists
+func LoadRules(database Queryer, exerciseType string) (*Rules, error) {
+	rules := &Rules{}
+	sqlStatement := `SELECT MAX_CALORIES_PER_MINUTE, MAX_DURATION, PLAUSIBILITY_MODE FROM exercise_types WHERE TYPE=$1`
+	err := database.QueryRow(sqlStatement, exerciseType).Scan(&rules.MaxCaloriesPerMinute, &rules.MaxDuration, &rules.Mode)
+	if err == sql.ErrNoRows {
+		return &Rules{MaxCaloriesPerMinute: DefaultMaxCaloriesPerMinute, MaxDuration: DefaultMaxDuration, Mode: DefaultMode}, nil
+	}
+
+	return rules, err
+}
+
+// Check the values of an exercise against the rules of its type, returning why it is flagged when it must be
+func Check(database Queryer, exerciseType string, startTime time.Time, duration int64, calories int64, distance float64) (string, error) {
+	if duration < 0 || calories < 0 || distance < 0 {
+		return "", ErrNegativeValue
+	}
+
+	if startTime.After(time.Now().Add(clockSkew)) {
+		return "", ErrFutureStartTime
+	}
+
+	rules, err := LoadRules(database, exerciseType)
+	if err != nil {
+		return "", err
+	}
+
+	var broken error
+	var reason string
+	switch {
+	case rules.MaxDuration > 0 && duration > rules.MaxDuration:
+		broken = ErrImplausibleDuration
+		reason = fmt.Sprintf("duration of %d seconds above the %d allowed", duration, rules.MaxDuration)
+	case rules.MaxCaloriesPerMinute > 0 && duration > 0 && float64(calories)/(float64(duration)/60) > rules.MaxCaloriesPerMinute:
+		broken = ErrImplausibleCalories
+		reason = fmt.Sprintf("%.1f calories per minute above the %g allowed", float64(calories)/(float64(duration)/60), rules.MaxCaloriesPerMinute)
+	default:
+		return "", nil
+	}
+
+	if rules.Mode == RejectMode {
+		return "", broken
+	}
+
+	return reason, nil
+}
+
+// StatusOf an exercise flagged for the reason, accepted when there is none
+func StatusOf(reason string) Status {
+	if reason != "" {
+		return FlaggedStatus
+	}
+
+	return AcceptedStatus
+}
+
+// Revise the status of a modified exercise, reviews stand unless the new values are flagged
+func Revise(stored Status, reason string) Status {
+	switch {
+	case stored == RejectedStatus:
+		return RejectedStatus
+	case stored == ApprovedStatus && reason == "":
+		return ApprovedStatus
+	default:
+		return StatusOf(reason)
+	}
+}
@@ -0,0 +1,267 @@
+package records
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	auth "../authenticate-request"
+	"github.com/gorilla/mux"
+)
+
+// Kind what a personal record measures
+type Kind string
+
+var (
+	// ErrInvalidUserID Error when user id is not valid
+	ErrInvalidUserID = errors.New("Invalid user id")
+)
+
+const (
+	// LongestDurationKind seconds of the longest exercise
+	LongestDurationKind Kind = "LONGEST_DURATION"
+	// MostCaloriesKind calories of the exercise that burnt the most, only measured ones count
+	MostCaloriesKind Kind = "MOST_CALORIES"
+	// FastestPaceKind seconds per kilometer of the fastest exercise with a distance
+	FastestPaceKind Kind = "FASTEST_PACE"
+)
+
+// best how the exercise holding a kind of record is found
+type best struct {
+	kind Kind
+	// value of an exercise the record is about
+	value string
+	// condition an exercise must meet to hold the record
+	condition string
+	// order the best exercise comes first in, the earliest one keeps the record on ties
+	order string
+}
+
+var bests = []best{
+	{LongestDurationKind, "DURATION", "DURATION > 0", "DURATION DESC"},
+	{MostCaloriesKind, "CALORIES", "CALORIES > 0 AND CALORIES_ESTIMATED = 0", "CALORIES DESC"},
+	{FastestPaceKind, "DURATION * 1000.0 / DISTANCE", "DISTANCE > 0", "DURATION * 1000.0 / DISTANCE ASC"},
+}
+
+// Record best exercise of a user for a type
+type Record struct {
+	UserID       int64     `json:"userId"`
+	ExerciseType string    `json:"type"`
+	Kind         Kind      `json:"kind"`
+	Value        float64   `json:"value"`
+	ExerciseID   int64     `json:"exerciseId"`
+	AchievedAt   time.Time `json:"achievedAt"`
+	// Previous value of the record beaten, none when it is the first of its kind
+	Previous *float64 `json:"previous,omitempty"`
+}
+
+// Queryer database or transaction the records are read from and written to
+type Queryer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// Response for /users/{userId}/records
+type Response struct {
+	Records []*Record `json:"records,omitempty"`
+	Error   string    `json:"error,omitempty"`
+}
+
+func openDatabase() (*sql.DB, error) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+
+	return sql.Open("sqlite3", fmt.Sprintf("%s/egym.db", dir))
+}
+
+// beats whether a value is better than the one of the record
+func (b best) beats(value float64, record float64) bool {
+	if b.kind == FastestPaceKind {
+		return value < record
+	}
+
+	return value > record
+}
+
+// find the ranked exercise of the user and type holding the record, nil when none qualifies
+func (b best) find(database Queryer, userID int64, exerciseType string) (*Record, error) {
+	record := &Record{UserID: userID, ExerciseType: exerciseType, Kind: b.kind}
+	query := fmt.Sprintf(`SELECT ID, %s, START_TIME FROM exercises WHERE USER_ID=$1 AND TYPE=$2 AND STATUS IN ('ACCEPTED', 'APPROVED') AND %s ORDER BY %s, START_TIME LIMIT 1`, b.value, b.condition, b.order)
+	err := database.QueryRow(query, userID, exerciseType).Scan(&record.ExerciseID, &record.Value, &record.AchievedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return record, nil
+}
+
+func getRecord(database Queryer, userID int64, exerciseType string, kind Kind) (*Record, error) {
+	record := &Record{UserID: userID, ExerciseType: exerciseType, Kind: kind}
+	err := database.QueryRow(`SELECT VALUE, EXERCISE_ID, ACHIEVED_AT FROM personal_records WHERE USER_ID=$1 AND TYPE=$2 AND KIND=$3`, userID, exerciseType, kind).Scan(&record.Value, &record.ExerciseID, &record.AchievedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return record, nil
+}
+
+func saveRecord(database Queryer, record *Record) error {
+	_, err := database.Exec(`INSERT OR REPLACE INTO personal_records (USER_ID, TYPE, KIND, VALUE, EXERCISE_ID, ACHIEVED_AT) VALUES ($1, $2, $3, $4, $5, $6)`, record.UserID, record.ExerciseType, record.Kind, record.Value, record.ExerciseID, record.AchievedAt.UTC())
+	return err
+}
+
+// Detect the records of a user for a type after their exercises changed, returning the ones the exercise saved beat, the others go back to the next best exercise
+func Detect(database Queryer, userID int64, exerciseType string, exerciseID int64) ([]*Record, error) {
+	beaten := []*Record{}
+	for _, b := range bests {
+		current, err := b.find(database, userID, exerciseType)
+		if err != nil {
+			return nil, err
+		}
+
+		stored, err := getRecord(database, userID, exerciseType, b.kind)
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case current == nil && stored != nil:
+			_, err = database.Exec(`DELETE FROM personal_records WHERE USER_ID=$1 AND TYPE=$2 AND KIND=$3`, userID, exerciseType, b.kind)
+		case current == nil:
+		case stored == nil:
+			err = saveRecord(database, current)
+		case b.beats(current.Value, stored.Value):
+			current.Previous = &stored.Value
+			err = saveRecord(database, current)
+		case current.ExerciseID != stored.ExerciseID || current.Value != stored.Value:
+			err = saveRecord(database, current)
+			current = nil
+		default:
+			current = nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		// another exercise taking the record, e.g. when the one holding it is deleted, was not beaten by the saved one
+		if current != nil && current.ExerciseID == exerciseID {
+			beaten = append(beaten, current)
+		}
+	}
+
+	return beaten, nil
+}
+
+// Backfill the records of the users and types with exercises but none stored, as those created before records were kept
+func Backfill(database *sql.DB) error {
+	type pair struct {
+		userID       int64
+		exerciseType string
+	}
+
+	result, err := database.Query(`SELECT DISTINCT USER_ID, TYPE FROM exercises e WHERE NOT EXISTS (SELECT 1 FROM personal_records p WHERE p.USER_ID = e.USER_ID AND p.TYPE = e.TYPE)`)
+	if err != nil {
+		return err
+	}
+
+	pairs := []pair{}
+	for result.Next() {
+		p := pair{}
+		if err := result.Scan(&p.userID, &p.exerciseType); err != nil {
+			result.Close()
+			return err
+		}
+
+		pairs = append(pairs, p)
+	}
+	result.Close()
+
+	for _, p := range pairs {
+		if _, err := Detect(database, p.userID, p.exerciseType, 0); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Forget the records of a user left without exercises
+func Forget(database Queryer, userID int64) error {
+	_, err := database.Exec(`DELETE FROM personal_records WHERE USER_ID=$1`, userID)
+	return err
+}
+
+func getRecords(database *sql.DB, userID int64) ([]*Record, error) {
+	result, err := database.Query(`SELECT USER_ID, TYPE, KIND, VALUE, EXERCISE_ID, ACHIEVED_AT FROM personal_records WHERE USER_ID=$1 ORDER BY TYPE, KIND`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer result.Close()
+
+	records := []*Record{}
+	for result.Next() {
+		record := &Record{}
+		if err := result.Scan(&record.UserID, &record.ExerciseType, &record.Kind, &record.Value, &record.ExerciseID, &record.AchievedAt); err != nil {
+			return nil, err
+		}
+
+		records = append(records, record)
+	}
+
+	return records, result.Err()
+}
+
+func response(w http.ResponseWriter, httpStatus int, response *Response, err error) {
+	if err != nil {
+		response.Error = err.Error()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(httpStatus)
+	json.NewEncoder(w).Encode(response)
+}
+
+// RecordsEndpoint function that returns the personal records of a user by type
+func RecordsEndpoint(w http.ResponseWriter, r *http.Request) {
+	newResponse := &Response{}
+	params := mux.Vars(r)
+
+	userID, err := strconv.ParseInt(params["userId"], 10, 64)
+	if err != nil {
+		response(w, http.StatusBadRequest, newResponse, ErrInvalidUserID)
+		return
+	}
+
+	if !auth.FromRequest(r).CanActFor(userID) {
+		response(w, http.StatusForbidden, newResponse, auth.ErrForbidden)
+		return
+	}
+
+	database, err := openDatabase()
+	if err != nil {
+		response(w, http.StatusInternalServerError, newResponse, err)
+		return
+	}
+
+	records, err := getRecords(database, userID)
+	if err != nil {
+		response(w, http.StatusInternalServerError, newResponse, err)
+		return
+	}
+
+	newResponse.Records = records
+	response(w, http.StatusOK, newResponse, err)
+}
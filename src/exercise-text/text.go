@@ -0,0 +1,79 @@
+package text
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+var (
+	// ErrInvalidDescription Error when description field has characters other than letters, numbers, spaces and punctuation
+	ErrInvalidDescription = errors.New("Invalid description must only have letters, numbers, spaces and punctuation")
+	// ErrDescriptionTooLong Error when description field is longer than allowed
+	ErrDescriptionTooLong = errors.New("Invalid description must not be longer than 100 characters")
+	// ErrInvalidNotes Error when notes field has control characters other than line breaks and tabs
+	ErrInvalidNotes = errors.New("Invalid notes must only have printable characters, line breaks and tabs")
+	// ErrNotesTooLong Error when notes field is longer than allowed
+	ErrNotesTooLong = errors.New("Invalid notes must not be longer than 1000 characters")
+)
+
+const (
+	// MaxDescriptionLength characters of a description, counted once normalized
+	MaxDescriptionLength = 100
+	// MaxNotesLength characters of the notes, counted once normalized
+	MaxNotesLength = 1000
+)
+
+// Normalize composes accents and marks so the same text is always stored with the same characters
+func Normalize(s string) string {
+	return norm.NFC.String(s)
+}
+
+func isDescriptionRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsMark(r) || unicode.IsNumber(r) || unicode.IsPunct(r) || r == ' '
+}
+
+// Description normalized with its spaces collapsed, in any language
+func Description(description string) (string, error) {
+	description = strings.Join(strings.Fields(Normalize(description)), " ")
+	if description == "" || strings.IndexFunc(description, func(r rune) bool { return !isDescriptionRune(r) }) != -1 {
+		return "", ErrInvalidDescription
+	}
+
+	if utf8.RuneCountInString(description) > MaxDescriptionLength {
+		return "", ErrDescriptionTooLong
+	}
+
+	return description, nil
+}
+
+func isNameRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsMark(r) || unicode.IsNumber(r) || r == ' '
+}
+
+// Name normalized with its spaces collapsed, false when it has characters other than letters, numbers and spaces in any language
+func Name(name string) (string, bool) {
+	name = strings.Join(strings.Fields(Normalize(name)), " ")
+	return name, strings.IndexFunc(name, func(r rune) bool { return !isNameRune(r) }) == -1
+}
+
+func isNotesRune(r rune) bool {
+	return unicode.IsGraphic(r) || r == '\n' || r == '\t'
+}
+
+// Notes normalized with their line breaks as \n and without surrounding spaces, empty meaning none
+func Notes(notes string) (string, error) {
+	notes = strings.TrimSpace(strings.Replace(Normalize(notes), "\r\n", "\n", -1))
+	if strings.IndexFunc(notes, func(r rune) bool { return !isNotesRune(r) }) != -1 {
+		return "", ErrInvalidNotes
+	}
+
+	if utf8.RuneCountInString(notes) > MaxNotesLength {
+		return "", ErrNotesTooLong
+	}
+
+	return notes, nil
+}
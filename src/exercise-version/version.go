@@ -0,0 +1,55 @@
+package version
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+var (
+	// ErrPreconditionRequired Error when a modification is received without If-Match header
+	ErrPreconditionRequired = errors.New("Missing If-Match header with the ETag of the exercise")
+	// ErrPreconditionFailed Error when the exercise changed since the ETag was issued
+	ErrPreconditionFailed = errors.New("The exercise has been modified since you last read it")
+)
+
+// ETag entity tag of a version of an exercise
+func ETag(exerciseID int64, version int64) string {
+	return fmt.Sprintf(`"%d-%d"`, exerciseID, version)
+}
+
+// SetETag adds the ETag header to the response
+func SetETag(w http.ResponseWriter, exerciseID int64, version int64) {
+	w.Header().Set("ETag", ETag(exerciseID, version))
+}
+
+// CheckIfMatch verifies the If-Match header of the request matches the current version
+func CheckIfMatch(r *http.Request, exerciseID int64, version int64) error {
+	ifMatch := strings.TrimSpace(r.Header.Get("If-Match"))
+	if ifMatch == "" {
+		return ErrPreconditionRequired
+	}
+
+	if ifMatch == "*" {
+		return nil
+	}
+
+	current := ETag(exerciseID, version)
+	for _, etag := range strings.Split(ifMatch, ",") {
+		if strings.TrimSpace(etag) == current {
+			return nil
+		}
+	}
+
+	return ErrPreconditionFailed
+}
+
+// Status http status of the errors returned by CheckIfMatch
+func Status(err error) int {
+	if err == ErrPreconditionRequired {
+		return http.StatusPreconditionRequired
+	}
+
+	return http.StatusPreconditionFailed
+}
@@ -0,0 +1,374 @@
+package export
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	auth "../authenticate-request"
+	heartrate "../exercise-heartrate"
+	circuits "../manage-circuits"
+	sets "../manage-sets"
+	swims "../manage-swims"
+	tags "../manage-tags"
+)
+
+var (
+	// ErrInvalidFormat Error when format param is not supported
+	ErrInvalidFormat = errors.New("Invalid format only csv is supported")
+	// ErrInvalidUserID Error when userId param is not valid
+	ErrInvalidUserID = errors.New("Invalid param userId")
+	// ErrInvalidDate Error when from or to params are not valid dates
+	ErrInvalidDate = errors.New("Invalid from or to params must be YYYY-MM-DD or ISO8601")
+)
+
+const (
+	csvFormat  = "csv"
+	dateFormat = "2006-01-02"
+	flushEvery = 100
+)
+
+// Columns header of the exported CSV, in the order the import expects them, sets, circuit, swim and heart rate samples as JSON
+var Columns = []string{"id", "userId", "description", "type", "startTime", "duration", "calories", "distance", "intensity", "caloriesEstimated", "timezone", "notes", "tags", "sets", "circuit", "swim", "heartRate", "status", "flagReason"}
+
+// formulaPrefixes characters spreadsheets start a formula with
+const formulaPrefixes = "=+-@"
+
+// Filter exercises to export
+type Filter struct {
+	UserIDs []int64
+	From    time.Time
+	To      time.Time
+	// Tags the exercises must have every one of
+	Tags []string
+}
+
+// Response for /exercises/export errors
+type Response struct {
+	Error string `json:"error,omitempty"`
+}
+
+func openDatabase() (*sql.DB, error) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+
+	return sql.Open("sqlite3", fmt.Sprintf("%s/egym.db", dir))
+}
+
+func parseDate(value string) (time.Time, error) {
+	if date, err := time.Parse(time.RFC3339, value); err == nil {
+		return date, nil
+	}
+
+	date, err := time.Parse(dateFormat, value)
+	if err != nil {
+		return time.Time{}, ErrInvalidDate
+	}
+
+	return date, nil
+}
+
+func getFilter(r *http.Request) (*Filter, error) {
+	query := r.URL.Query()
+	filter := &Filter{}
+
+	for _, value := range query["userId"] {
+		userID, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, ErrInvalidUserID
+		}
+
+		filter.UserIDs = append(filter.UserIDs, userID)
+	}
+
+	for _, value := range query["tag"] {
+		tag, err := tags.Normalize(value)
+		if err != nil {
+			return nil, err
+		}
+
+		filter.Tags = append(filter.Tags, tag)
+	}
+
+	var err error
+	if query.Get("from") != "" {
+		if filter.From, err = parseDate(query.Get("from")); err != nil {
+			return nil, err
+		}
+	}
+
+	if query.Get("to") != "" {
+		if filter.To, err = parseDate(query.Get("to")); err != nil {
+			return nil, err
+		}
+
+		// a date includes the whole day
+		if len(query.Get("to")) == len(dateFormat) {
+			filter.To = filter.To.AddDate(0, 0, 1)
+		}
+	}
+
+	return filter, nil
+}
+
+// authorize restricts users to their own exercises, admins may export anyone's
+func (f *Filter) authorize(principal *auth.Principal) error {
+	if principal.HasRole(auth.AdminRole) {
+		return nil
+	}
+
+	if len(f.UserIDs) == 0 {
+		f.UserIDs = []int64{principal.UserID}
+	}
+
+	for _, userID := range f.UserIDs {
+		if !principal.CanActFor(userID) {
+			return auth.ErrForbidden
+		}
+	}
+
+	return nil
+}
+
+// EscapeFormula prefixes with a quote the text a spreadsheet would run as a formula, and the text already starting with one so it comes back as it was
+func EscapeFormula(value string) string {
+	if value != "" && strings.ContainsRune(formulaPrefixes+"'", rune(value[0])) {
+		return "'" + value
+	}
+
+	return value
+}
+
+// UnescapeFormula removes the quote EscapeFormula prefixed the text with
+func UnescapeFormula(value string) string {
+	return strings.TrimPrefix(value, "'")
+}
+
+// escapeTags separated by spaces one by one, as the import splits them
+func escapeTags(exerciseTags string) string {
+	escaped := strings.Fields(exerciseTags)
+	for index, tag := range escaped {
+		escaped[index] = EscapeFormula(tag)
+	}
+
+	return strings.Join(escaped, " ")
+}
+
+// encodeJSON a child of an exercise, empty when it has none
+func encodeJSON(value interface{}, empty bool) (string, error) {
+	if empty {
+		return "", nil
+	}
+
+	encoded, err := json.Marshal(value)
+	return string(encoded), err
+}
+
+// children sets, circuit, swim and heart rate samples of an exercise encoded as JSON
+func children(database *sql.DB, exerciseID int64, duration int64) ([]string, error) {
+	exerciseSets, err := sets.Load(database, exerciseID)
+	if err != nil {
+		return nil, err
+	}
+
+	circuit, err := circuits.Load(database, exerciseID)
+	if err != nil {
+		return nil, err
+	}
+
+	// the rounds are derived from the stations, the import expands them again
+	if circuit != nil {
+		circuit.Rounds = nil
+	}
+
+	swim, err := swims.Load(database, exerciseID, duration)
+	if err != nil {
+		return nil, err
+	}
+
+	heartRate, err := heartrate.Load(database, exerciseID, true)
+	if err != nil {
+		return nil, err
+	}
+
+	encoded := make([]string, 4)
+	if encoded[0], err = encodeJSON(exerciseSets, len(exerciseSets) == 0); err != nil {
+		return nil, err
+	}
+
+	if encoded[1], err = encodeJSON(circuit, circuit == nil); err != nil {
+		return nil, err
+	}
+
+	if encoded[2], err = encodeJSON(swim, swim == nil); err != nil {
+		return nil, err
+	}
+
+	if heartRate != nil {
+		encoded[3], err = encodeJSON(heartRate.Samples, false)
+	}
+
+	return encoded, err
+}
+
+func (f *Filter) query() (string, []interface{}) {
+	conditions := []string{"1=1"}
+	args := []interface{}{}
+
+	if len(f.UserIDs) > 0 {
+		placeholders := []string{}
+		for _, userID := range f.UserIDs {
+			args = append(args, userID)
+			placeholders = append(placeholders, fmt.Sprintf("$%d", len(args)))
+		}
+
+		conditions = append(conditions, fmt.Sprintf("USER_ID IN (%s)", strings.Join(placeholders, ", ")))
+	}
+
+	if !f.From.IsZero() {
+		args = append(args, f.From)
+		conditions = append(conditions, fmt.Sprintf("START_TIME >= $%d", len(args)))
+	}
+
+	if !f.To.IsZero() {
+		args = append(args, f.To)
+		conditions = append(conditions, fmt.Sprintf("START_TIME < $%d", len(args)))
+	}
+
+	for _, tag := range f.Tags {
+		args = append(args, tag)
+		conditions = append(conditions, fmt.Sprintf("ID IN (SELECT EXERCISE_ID FROM exercise_tags WHERE TAG = $%d)", len(args)))
+	}
+
+	query := fmt.Sprintf(`SELECT ID, USER_ID, DESCRIPTION, TYPE, START_TIME, DURATION, CALORIES, DISTANCE, INTENSITY, CALORIES_ESTIMATED, TIMEZONE, NOTES, COALESCE((SELECT GROUP_CONCAT(TAG, ' ') FROM (SELECT TAG FROM exercise_tags WHERE EXERCISE_ID=exercises.ID ORDER BY TAG)), ''), STATUS, FLAG_REASON FROM exercises WHERE %s ORDER BY USER_ID, START_TIME`, strings.Join(conditions, " AND "))
+
+	return query, args
+}
+
+func writeCSV(w http.ResponseWriter, database *sql.DB, result *sql.Rows) error {
+	writer := csv.NewWriter(w)
+	flusher, canFlush := w.(http.Flusher)
+
+	if err := writer.Write(Columns); err != nil {
+		return err
+	}
+
+	for rows := 1; result.Next(); rows++ {
+		var ID, userID, duration, calories int64
+		// tags are separated by spaces, they can not have any
+		var description, exerciseType, intensity, timezone, notes, exerciseTags, status, flagReason string
+		var startTime time.Time
+		var distance float64
+		var caloriesEstimated bool
+
+		if err := result.Scan(&ID, &userID, &description, &exerciseType, &startTime, &duration, &calories, &distance, &intensity, &caloriesEstimated, &timezone, &notes, &exerciseTags, &status, &flagReason); err != nil {
+			return err
+		}
+
+		encodedChildren, err := children(database, ID, duration)
+		if err != nil {
+			return err
+		}
+
+		record := []string{
+			strconv.FormatInt(ID, 10),
+			strconv.FormatInt(userID, 10),
+			EscapeFormula(description),
+			exerciseType,
+			startTime.Format(time.RFC3339Nano),
+			strconv.FormatInt(duration, 10),
+			strconv.FormatInt(calories, 10),
+			strconv.FormatFloat(distance, 'f', -1, 64),
+			intensity,
+			strconv.FormatBool(caloriesEstimated),
+			timezone,
+			EscapeFormula(notes),
+			escapeTags(exerciseTags),
+			encodedChildren[0],
+			encodedChildren[1],
+			encodedChildren[2],
+			encodedChildren[3],
+			status,
+			EscapeFormula(flagReason),
+		}
+
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+
+		if rows%flushEvery == 0 {
+			writer.Flush()
+			if canFlush {
+				flusher.Flush()
+			}
+		}
+	}
+
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return err
+	}
+
+	return result.Err()
+}
+
+func response(w http.ResponseWriter, httpStatus int, response *Response, err error) {
+	if err != nil {
+		response.Error = err.Error()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(httpStatus)
+	json.NewEncoder(w).Encode(response)
+}
+
+// ExportEndpoint function that streams the exercises matching the filters as CSV
+func ExportEndpoint(w http.ResponseWriter, r *http.Request) {
+	newResponse := &Response{}
+
+	if format := r.URL.Query().Get("format"); format != "" && format != csvFormat {
+		response(w, http.StatusBadRequest, newResponse, ErrInvalidFormat)
+		return
+	}
+
+	filter, err := getFilter(r)
+	if err != nil {
+		response(w, http.StatusBadRequest, newResponse, err)
+		return
+	}
+
+	if err = filter.authorize(auth.FromRequest(r)); err != nil {
+		response(w, http.StatusForbidden, newResponse, err)
+		return
+	}
+
+	database, err := openDatabase()
+	if err != nil {
+		response(w, http.StatusInternalServerError, newResponse, err)
+		return
+	}
+
+	query, args := filter.query()
+	result, err := database.Query(query, args...)
+	if err != nil {
+		response(w, http.StatusInternalServerError, newResponse, err)
+		return
+	}
+	defer result.Close()
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", `attachment; filename="exercises.csv"`)
+	w.WriteHeader(http.StatusOK)
+
+	// the status is already sent, a failure can only cut the stream short
+	writeCSV(w, database, result)
+}
@@ -0,0 +1,183 @@
+package get
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	auth "../authenticate-request"
+	heartrate "../exercise-heartrate"
+	laps "../exercise-laps"
+	metrics "../exercise-metrics"
+	version "../exercise-version"
+	circuits "../manage-circuits"
+	sets "../manage-sets"
+	swims "../manage-swims"
+	tags "../manage-tags"
+	workout "../parse-workout"
+	"github.com/gorilla/mux"
+)
+
+// ExerciseType Type of the Exercise
+type ExerciseType string
+
+var (
+	// ErrInvalidID Error when ID field is not valid
+	ErrInvalidID = errors.New("Invalid exercise id")
+	// ErrNoExerciseFound The exercise you requested does not exists
+	ErrNoExerciseFound = errors.New("The exercise you requested does not exists")
+)
+
+// Exercise structure
+type Exercise struct {
+	// ID field of Exercise
+	ID int64 `json:"id"`
+	// UserID id field of User
+	UserID int64 `json:"userId"`
+	// Description of the Exercise
+	Description string `json:"description"`
+	// Notes free text about the exercise
+	Notes string `json:"notes,omitempty"`
+	// Tags labels of the exercise
+	Tags []string `json:"tags,omitempty"`
+	// ExerciseType type of the exercise
+	ExerciseType ExerciseType `json:"type"`
+	// StartTime time when exercise starts, in UTC
+	StartTime time.Time `json:"startTime"`
+	// Timezone IANA time zone the exercise took place in
+	Timezone string `json:"timezone"`
+	// Duration duration of the exercise
+	Duration int64 `json:"duration"`
+	// Calories burnt on the exercise
+	Calories int64 `json:"calories"`
+	// CaloriesEstimated whether the calories were estimated instead of measured
+	CaloriesEstimated bool `json:"caloriesEstimated,omitempty"`
+	// Intensity of the exercise the calories are estimated with
+	Intensity string `json:"intensity,omitempty"`
+	// Distance meters covered on the exercise
+	Distance float64 `json:"distance,omitempty"`
+	// Status ACCEPTED or APPROVED when ranked, FLAGGED until reviewed, REJECTED when not ranked
+	Status string `json:"status"`
+	// FlagReason rule of the type the exercise breaks when flagged
+	FlagReason string `json:"flagReason,omitempty"`
+	// Metrics speed and pace derived from the distance
+	Metrics *metrics.Metrics `json:"metrics,omitempty"`
+	// Sets performed on a strength training exercise
+	Sets []*sets.Set `json:"sets,omitempty"`
+	// Volume kilograms lifted on the sets
+	Volume float64 `json:"volume,omitempty"`
+	// Circuit stations and rounds of a circuit training exercise
+	Circuit *circuits.Circuit `json:"circuit,omitempty"`
+	// Swim pool length, laps and strokes of a swimming exercise
+	Swim *swims.Swim `json:"swim,omitempty"`
+	// HeartRate summary of the heart rate samples attached to the exercise
+	HeartRate *heartrate.HeartRate `json:"heartRate,omitempty"`
+	// Laps recorded by the device on an uploaded workout
+	Laps []*workout.Lap `json:"laps,omitempty"`
+	// Version incremented on every modification, sent as ETag
+	Version int64 `json:"-"`
+}
+
+// Response for /exercise/{exerciseId}
+type Response struct {
+	Exercise *Exercise `json:"exercise,omitempty"`
+	Error    string    `json:"error,omitempty"`
+}
+
+func getExercise(ID int64) (*Exercise, error) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	database, err := sql.Open("sqlite3", fmt.Sprintf("%s/egym.db", dir))
+	if err != nil {
+		return nil, err
+	}
+
+	exercise := &Exercise{ID: ID}
+	sqlStatement := `SELECT USER_ID, DESCRIPTION, NOTES, TYPE, START_TIME, TIMEZONE, DURATION, CALORIES, CALORIES_ESTIMATED, INTENSITY, DISTANCE, STATUS, FLAG_REASON, VERSION FROM exercises WHERE ID=$1;`
+	err = database.QueryRow(sqlStatement, ID).Scan(&exercise.UserID, &exercise.Description, &exercise.Notes, &exercise.ExerciseType, &exercise.StartTime, &exercise.Timezone, &exercise.Duration, &exercise.Calories, &exercise.CaloriesEstimated, &exercise.Intensity, &exercise.Distance, &exercise.Status, &exercise.FlagReason, &exercise.Version)
+	if err == sql.ErrNoRows {
+		return nil, ErrNoExerciseFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	exercise.Metrics = metrics.Calculate(string(exercise.ExerciseType), exercise.Duration, exercise.Distance)
+	if exercise.Sets, err = sets.Load(database, ID); err != nil {
+		return nil, err
+	}
+	exercise.Volume = sets.Volume(exercise.Sets)
+
+	if exercise.Circuit, err = circuits.Load(database, ID); err != nil {
+		return nil, err
+	}
+
+	if exercise.Swim, err = swims.Load(database, ID, exercise.Duration); err != nil {
+		return nil, err
+	}
+
+	if exercise.Tags, err = tags.Load(database, ID); err != nil {
+		return nil, err
+	}
+
+	if exercise.HeartRate, err = heartrate.Load(database, ID, false); err != nil {
+		return nil, err
+	}
+
+	exercise.Laps, err = laps.Load(database, ID)
+
+	return exercise, err
+}
+
+func response(w http.ResponseWriter, httpStatus int, response *Response, err error) {
+	if err != nil {
+		response.Error = err.Error()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(httpStatus)
+	json.NewEncoder(w).Encode(response)
+}
+
+// ExerciseEndpoint function that handles request and response
+func ExerciseEndpoint(w http.ResponseWriter, r *http.Request) {
+	newResponse := &Response{}
+	params := mux.Vars(r)
+
+	exerciseID, err := strconv.ParseInt(params["exerciseId"], 10, 64)
+	if err != nil {
+		response(w, http.StatusBadRequest, newResponse, ErrInvalidID)
+		return
+	}
+
+	exercise, err := getExercise(exerciseID)
+	if err == ErrNoExerciseFound {
+		response(w, http.StatusNotFound, newResponse, err)
+		return
+	}
+	if err != nil {
+		response(w, http.StatusInternalServerError, newResponse, err)
+		return
+	}
+
+	if !auth.FromRequest(r).CanActFor(exercise.UserID) {
+		response(w, http.StatusForbidden, newResponse, auth.ErrForbidden)
+		return
+	}
+
+	version.SetETag(w, exercise.ID, exercise.Version)
+	if r.Header.Get("If-None-Match") == version.ETag(exercise.ID, exercise.Version) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	newResponse.Exercise = exercise
+	response(w, http.StatusOK, newResponse, err)
+}
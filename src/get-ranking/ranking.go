@@ -9,6 +9,9 @@ import (
 	"os"
 	"sort"
 	"time"
+
+	auth "../authenticate-request"
+	tags "../manage-tags"
 )
 
 // ExerciseType Type of the Exercise
@@ -17,20 +20,8 @@ type ExerciseType string
 var (
 	// ErrInvalidUserIDs Error when userIDs params is invalid
 	ErrInvalidUserIDs = errors.New("Invalid params userIDs")
-
-	exerciseTypes = map[ExerciseType]ExerciseType{
-		RunningType:          RunningType,
-		SwimmingType:         SwimmingType,
-		StrenghtTrainingType: StrenghtTrainingType,
-		CircuitTrainingType:  CircuitTrainingType,
-	}
-
-	getMultiplicationFactor = map[ExerciseType]int{
-		RunningType:          2,
-		SwimmingType:         3,
-		StrenghtTrainingType: 3,
-		CircuitTrainingType:  4,
-	}
+	// ErrInvalidTimezone Error when tz param is not an IANA time zone
+	ErrInvalidTimezone = errors.New("Invalid param tz must be an IANA time zone")
 )
 
 const (
@@ -42,6 +33,8 @@ const (
 	StrenghtTrainingType ExerciseType = "STRENGTH_TRAINING"
 	// CircuitTrainingType Exercise type for circuit training
 	CircuitTrainingType ExerciseType = "CIRCUIT_TRAINING"
+
+	dateFormat = "2006-01-02"
 )
 
 // Row is a user struct
@@ -49,7 +42,22 @@ type Row struct {
 	ExerciseType string
 	Duration     int64
 	Calories     int64
-	FinishTime   time.Time
+	// CaloriesEstimated whether the calories were estimated instead of measured
+	CaloriesEstimated bool
+	Distance          float64
+	TrainingLoad      float64
+	FinishTime        time.Time
+}
+
+// Scoring factors the exercises of a type are ranked with
+type Scoring struct {
+	MultiplicationFactor int
+	// DistanceFactor points per kilometer
+	DistanceFactor float64
+	// LoadFactor points per unit of heart rate training load
+	LoadFactor float64
+	// EstimatedCaloriesFactor share of the estimated calories that is ranked
+	EstimatedCaloriesFactor float64
 }
 
 // PointsByType points of user by type
@@ -63,14 +71,27 @@ type PointsByType struct {
 // User is a user struct
 type User struct {
 	UserID           string
+	DisplayName      string
 	Points           float64
 	LastExerciseDate time.Time
 }
 
+// Window period of time in which exercises must start to be ranked, To excluded
+type Window struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+	// Tag only the exercises labeled with it are ranked when set
+	Tag string `json:"tag,omitempty"`
+}
+
 // Response for /exercise
 type Response struct {
-	Ranking []*User `json:"ranking,omitempty"` // use struct []*User inside []*PointsByType
-	Error   string  `json:"error,omitempty"`
+	Ranking []*User   `json:"ranking,omitempty"` // use struct []*User inside []*PointsByType
+	Teams   []*Team   `json:"teams,omitempty"`
+	Season  *Season   `json:"season,omitempty"`
+	Seasons []*Season `json:"seasons,omitempty"`
+	Window  *Window   `json:"window,omitempty"`
+	Error   string    `json:"error,omitempty"`
 }
 
 // ByPoints implements sort.Interface based on the points field
@@ -90,6 +111,62 @@ func (p ByPoints) Less(i, j int) bool {
 }
 func (p ByPoints) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
 
+// defaultWindow the last 29 days until yesterday, both included, as days of the time zone
+func defaultWindow(now time.Time, location *time.Location) Window {
+	now = now.In(location)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, location)
+
+	return Window{
+		From: today.AddDate(0, 0, -29),
+		To:   today,
+	}
+}
+
+// locationOf the ranking, the tz param or else the time zone of the user requesting it, UTC for service clients
+func locationOf(r *http.Request) (*time.Location, error) {
+	if r.URL.Query().Get("tz") != "" {
+		location, err := time.LoadLocation(r.URL.Query().Get("tz"))
+		if err != nil {
+			return nil, ErrInvalidTimezone
+		}
+
+		return location, nil
+	}
+
+	principal := auth.FromRequest(r)
+	if principal == nil || principal.UserID == 0 {
+		return time.UTC, nil
+	}
+
+	database, err := openDatabase()
+	if err != nil {
+		return nil, err
+	}
+
+	var timezone string
+	err = database.QueryRow(`SELECT TIMEZONE FROM users WHERE ID=$1`, principal.UserID).Scan(&timezone)
+	if err == sql.ErrNoRows {
+		return time.UTC, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return time.LoadLocation(timezone)
+}
+
+// tagOf the ranking, the tag param normalized as stored, empty when not received
+func tagOf(r *http.Request) (string, error) {
+	if r.URL.Query().Get("tag") == "" {
+		return "", nil
+	}
+
+	return tags.Normalize(r.URL.Query().Get("tag"))
+}
+
+func (w Window) from() string { return w.From.Format(dateFormat) }
+func (w Window) to() string   { return w.To.Format(dateFormat) }
+
 func totalPointsByUser(userID string, pointsByUser []*PointsByType) (*User, error) {
 	totalPointsByUser := &User{
 		UserID: userID,
@@ -112,7 +189,7 @@ func totalPointsByUser(userID string, pointsByUser []*PointsByType) (*User, erro
 	return totalPointsByUser, nil
 }
 
-func calculatePointsByExerciseType(userID string, exerciseType ExerciseType, exercises []Row) *PointsByType {
+func calculatePointsByExerciseType(userID string, exerciseType ExerciseType, scoring *Scoring, exercises []Row) *PointsByType {
 	pointsByType := &PointsByType{
 		UserID:       userID,
 		ExerciseType: exerciseType,
@@ -122,17 +199,22 @@ func calculatePointsByExerciseType(userID string, exerciseType ExerciseType, exe
 		pointsByType.LastExerciseDate = exercises[0].FinishTime
 	}
 
-	multiplicationFactor := getMultiplicationFactor[exerciseType]
 	percent := 100.0
 
 	for _, exercise := range exercises {
-		basePoints := (int64((exercise.Duration+59)/60) + exercise.Calories) * int64(multiplicationFactor)
+		// estimated calories are less reliable than measured ones
+		calories := float64(exercise.Calories)
+		if exercise.CaloriesEstimated {
+			calories *= scoring.EstimatedCaloriesFactor
+		}
+
+		basePoints := (float64((exercise.Duration+59)/60)+calories)*float64(scoring.MultiplicationFactor) + exercise.Distance/1000*scoring.DistanceFactor + exercise.TrainingLoad*scoring.LoadFactor
 
 		if percent <= 0 {
 			break
 		}
 
-		pointsByType.Points += float64(basePoints) * (percent / 100.0)
+		pointsByType.Points += basePoints * (percent / 100.0)
 		percent -= 10.0
 	}
 
@@ -145,7 +227,7 @@ func setResult(result *sql.Rows) ([]Row, error) {
 		var row Row
 		var finishTime string
 
-		if err := result.Scan(&row.ExerciseType, &row.Duration, &row.Calories, &finishTime); err != nil {
+		if err := result.Scan(&row.ExerciseType, &row.Duration, &row.Calories, &row.CaloriesEstimated, &row.Distance, &row.TrainingLoad, &finishTime); err != nil {
 			return nil, err
 		}
 
@@ -164,7 +246,7 @@ func setResult(result *sql.Rows) ([]Row, error) {
 	return userExercises, nil
 }
 
-func getExercisesByType(exerciseType ExerciseType, userID string) ([]Row, error) {
+func getExercisesByType(exerciseType ExerciseType, userID string, window Window) ([]Row, error) {
 	dir, err := os.Getwd()
 	if err != nil {
 		return nil, err
@@ -174,25 +256,71 @@ func getExercisesByType(exerciseType ExerciseType, userID string) ([]Row, error)
 		return nil, err
 	}
 
-	query := fmt.Sprintf(`%s%s%s%s%s`, `SELECT TYPE, DURATION, CALORIES, FINISH_TIME FROM exercises WHERE TYPE="`, exerciseType, `" AND USER_ID=`, userID, ` AND START_TIME BETWEEN DATE("NOW", "-29 days") AND DATE("NOW", "-1 day") ORDER BY START_TIME DESC`)
-	result, err := database.Query(query)
+	query := `SELECT e.TYPE, e.DURATION, e.CALORIES, e.CALORIES_ESTIMATED, e.DISTANCE, COALESCE(h.TRAINING_LOAD, 0), e.FINISH_TIME FROM exercises e LEFT JOIN exercise_heart_rates h ON h.EXERCISE_ID = e.ID WHERE e.TYPE=$1 AND e.USER_ID=$2 AND e.STATUS IN ('ACCEPTED', 'APPROVED') AND e.START_TIME >= $3 AND e.START_TIME < $4 AND ($5 = '' OR EXISTS (SELECT 1 FROM exercise_tags t WHERE t.EXERCISE_ID = e.ID AND t.TAG = $5)) ORDER BY e.START_TIME DESC`
+	result, err := database.Query(query, exerciseType, userID, window.From.UTC(), window.To.UTC(), window.Tag)
 	if err != nil {
 		return nil, err
 	}
 
-	userExercises, err := setResult(result)
-	return userExercises, nil
+	return setResult(result)
+}
+
+func getDisplayName(userID string) (string, error) {
+	database, err := openDatabase()
+	if err != nil {
+		return "", err
+	}
+
+	var displayName string
+	err = database.QueryRow(`SELECT DISPLAY_NAME FROM users WHERE ID=$1`, userID).Scan(&displayName)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+
+	return displayName, err
 }
 
-func getTotalPointsByUser(userID string) (*User, error) {
+// getScorings the factors of every exercise type managed through /admin
+func getScorings() (map[ExerciseType]*Scoring, error) {
+	database, err := openDatabase()
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := database.Query(`SELECT TYPE, MULTIPLICATION_FACTOR, DISTANCE_FACTOR, LOAD_FACTOR, ESTIMATED_CALORIES_FACTOR FROM exercise_types`)
+	if err != nil {
+		return nil, err
+	}
+	defer result.Close()
+
+	scorings := map[ExerciseType]*Scoring{}
+	for result.Next() {
+		var exerciseType ExerciseType
+		scoring := &Scoring{}
+		if err := result.Scan(&exerciseType, &scoring.MultiplicationFactor, &scoring.DistanceFactor, &scoring.LoadFactor, &scoring.EstimatedCaloriesFactor); err != nil {
+			return nil, err
+		}
+
+		scorings[exerciseType] = scoring
+	}
+
+	return scorings, result.Err()
+}
+
+func getTotalPointsByUser(userID string, window Window) (*User, error) {
+	scorings, err := getScorings()
+	if err != nil {
+		return nil, err
+	}
+
 	pointsByUser := []*PointsByType{}
-	for i := range exerciseTypes {
-		userExercises, err := getExercisesByType(exerciseTypes[i], userID)
+	for exerciseType, scoring := range scorings {
+		userExercises, err := getExercisesByType(exerciseType, userID, window)
 		if err != nil {
 			return nil, err
 		}
 
-		pointsByType := calculatePointsByExerciseType(userID, exerciseTypes[i], userExercises)
+		pointsByType := calculatePointsByExerciseType(userID, exerciseType, scoring, userExercises)
 		pointsByUser = append(pointsByUser, pointsByType)
 	}
 
@@ -201,13 +329,15 @@ func getTotalPointsByUser(userID string) (*User, error) {
 		return nil, err
 	}
 
+	totalPointsByUser.DisplayName, err = getDisplayName(userID)
+
 	return totalPointsByUser, err
 }
 
-func getTotalPoints(users []string) ([]*User, error) {
+func getTotalPoints(users []string, window Window) ([]*User, error) {
 	totalPoints := []*User{}
 	for _, userID := range users {
-		totalPointsByUser, err := getTotalPointsByUser(userID)
+		totalPointsByUser, err := getTotalPointsByUser(userID, window)
 		if err != nil {
 			return nil, err
 		}
@@ -238,7 +368,23 @@ func RankingEndpoint(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	totalPoints, err := getTotalPoints(users)
+	location, err := locationOf(r)
+	if err == ErrInvalidTimezone {
+		response(w, http.StatusBadRequest, newResponse, err)
+		return
+	}
+	if err != nil {
+		response(w, http.StatusInternalServerError, newResponse, err)
+		return
+	}
+
+	window := defaultWindow(time.Now(), location)
+	if window.Tag, err = tagOf(r); err != nil {
+		response(w, http.StatusBadRequest, newResponse, err)
+		return
+	}
+
+	totalPoints, err := getTotalPoints(users, window)
 	if err != nil {
 		response(w, http.StatusInternalServerError, newResponse, err)
 		return
@@ -247,5 +393,6 @@ func RankingEndpoint(w http.ResponseWriter, r *http.Request) {
 	sort.Sort(ByPoints(totalPoints)) // sort points of users by points
 
 	newResponse.Ranking = totalPoints
+	newResponse.Window = &window
 	response(w, http.StatusOK, newResponse, err)
 }
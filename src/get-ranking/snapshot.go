@@ -0,0 +1,310 @@
+package rank
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+var (
+	// ErrInvalidSeason Error when season id is not a YYYY-MM month
+	ErrInvalidSeason = errors.New("Invalid season must be formatted as YYYY-MM")
+	// ErrSeasonNotFound Error when the season has not been archived yet
+	ErrSeasonNotFound = errors.New("The season you requested has not been archived")
+	// ErrSeasonNotFinished Error when trying to archive a season that has not ended
+	ErrSeasonNotFinished = errors.New("The season you intended to archive has not finished yet")
+)
+
+const seasonFormat = "2006-01"
+
+// Audit records the snapshot of a season in the transaction it is saved in
+type Audit func(tx *sql.Tx, ranking []*User) error
+
+// Season monthly competition whose ranking is frozen once it ends
+type Season struct {
+	ID         string    `json:"id"`
+	StartDate  string    `json:"startDate"`
+	EndDate    string    `json:"endDate"`
+	ArchivedAt time.Time `json:"archivedAt"`
+}
+
+func openDatabase() (*sql.DB, error) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+
+	return sql.Open("sqlite3", fmt.Sprintf("%s/egym.db", dir))
+}
+
+// seasonWindow the window ranked by a season, from its first day until the first day of the next month
+func seasonWindow(seasonID string) (Window, error) {
+	start, err := time.Parse(seasonFormat, seasonID)
+	if err != nil {
+		return Window{}, ErrInvalidSeason
+	}
+
+	return Window{From: start, To: start.AddDate(0, 1, 0)}, nil
+}
+
+func getUsersInWindow(database *sql.DB, window Window) ([]string, error) {
+	result, err := database.Query(`SELECT DISTINCT USER_ID FROM exercises WHERE START_TIME >= $1 AND START_TIME < $2`, window.From.UTC(), window.To.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer result.Close()
+
+	users := []string{}
+	for result.Next() {
+		var userID string
+		if err := result.Scan(&userID); err != nil {
+			return nil, err
+		}
+
+		users = append(users, userID)
+	}
+
+	return users, result.Err()
+}
+
+func saveSnapshot(database *sql.DB, seasonID string, window Window, ranking []*User, audit Audit) error {
+	tx, err := database.Begin()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`DELETE FROM snapshots WHERE SEASON_ID=$1`, seasonID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	_, err = tx.Exec(`INSERT OR REPLACE INTO seasons (ID, START_DATE, END_DATE, ARCHIVED_AT) VALUES ($1, $2, $3, $4)`, seasonID, window.from(), window.to(), time.Now().UTC())
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	for position, user := range ranking {
+		_, err = tx.Exec(`INSERT INTO snapshots (SEASON_ID, POSITION, USER_ID, POINTS, LAST_EXERCISE_DATE) VALUES ($1, $2, $3, $4, $5)`, seasonID, position+1, user.UserID, user.Points, user.LastExerciseDate)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	if audit != nil {
+		if err = audit(tx, ranking); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// SnapshotSeason materialises the ranking of every user with exercises in the season, audited when audit is not nil
+func SnapshotSeason(seasonID string, audit Audit) ([]*User, error) {
+	window, err := seasonWindow(seasonID)
+	if err != nil {
+		return nil, err
+	}
+
+	if time.Now().Before(window.To) {
+		return nil, ErrSeasonNotFinished
+	}
+
+	database, err := openDatabase()
+	if err != nil {
+		return nil, err
+	}
+
+	users, err := getUsersInWindow(database, window)
+	if err != nil {
+		return nil, err
+	}
+
+	ranking, err := getTotalPoints(users, window)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Sort(ByPoints(ranking))
+
+	return ranking, saveSnapshot(database, seasonID, window, ranking, audit)
+}
+
+func isArchived(database *sql.DB, seasonID string) (bool, error) {
+	var total int
+	err := database.QueryRow(`SELECT COUNT(*) FROM seasons WHERE ID=$1`, seasonID).Scan(&total)
+
+	return total > 0, err
+}
+
+// finishedSeasons every season from the month of first until the one before the month of now, oldest first
+func finishedSeasons(first time.Time, now time.Time) []string {
+	first, now = first.UTC(), now.UTC()
+	current := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+
+	seasons := []string{}
+	for month := time.Date(first.Year(), first.Month(), 1, 0, 0, 0, 0, time.UTC); month.Before(current); month = month.AddDate(0, 1, 0) {
+		seasons = append(seasons, month.Format(seasonFormat))
+	}
+
+	return seasons
+}
+
+// unarchivedSeasons finished seasons since the first exercise that have not been archived yet, oldest first
+func unarchivedSeasons(database *sql.DB) ([]string, error) {
+	var first time.Time
+	err := database.QueryRow(`SELECT START_TIME FROM exercises ORDER BY START_TIME LIMIT 1`).Scan(&first)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	seasons := []string{}
+	for _, seasonID := range finishedSeasons(first, time.Now()) {
+		archived, err := isArchived(database, seasonID)
+		if err != nil {
+			return nil, err
+		}
+
+		if !archived {
+			seasons = append(seasons, seasonID)
+		}
+	}
+
+	return seasons, nil
+}
+
+// SnapshotJob archives every season that has ended without being archived, checking every interval
+func SnapshotJob(interval time.Duration) {
+	for {
+		database, err := openDatabase()
+		if err == nil {
+			var seasons []string
+			seasons, err = unarchivedSeasons(database)
+			for _, seasonID := range seasons {
+				if _, err := SnapshotSeason(seasonID, nil); err != nil {
+					log.Printf("snapshot of season %s failed: %v", seasonID, err)
+				}
+			}
+		}
+
+		if err != nil {
+			log.Printf("snapshot of seasons failed: %v", err)
+		}
+
+		time.Sleep(interval)
+	}
+}
+
+func getSeasons(database *sql.DB) ([]*Season, error) {
+	result, err := database.Query(`SELECT ID, START_DATE, END_DATE, ARCHIVED_AT FROM seasons ORDER BY ID DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer result.Close()
+
+	seasons := []*Season{}
+	for result.Next() {
+		season := &Season{}
+		if err := result.Scan(&season.ID, &season.StartDate, &season.EndDate, &season.ArchivedAt); err != nil {
+			return nil, err
+		}
+
+		seasons = append(seasons, season)
+	}
+
+	return seasons, result.Err()
+}
+
+func getSnapshot(database *sql.DB, seasonID string) (*Season, []*User, error) {
+	season := &Season{}
+	err := database.QueryRow(`SELECT ID, START_DATE, END_DATE, ARCHIVED_AT FROM seasons WHERE ID=$1`, seasonID).Scan(&season.ID, &season.StartDate, &season.EndDate, &season.ArchivedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil, ErrSeasonNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	result, err := database.Query(`SELECT s.USER_ID, COALESCE(u.DISPLAY_NAME, ''), s.POINTS, s.LAST_EXERCISE_DATE FROM snapshots s LEFT JOIN users u ON u.ID = s.USER_ID WHERE s.SEASON_ID=$1 ORDER BY s.POSITION`, seasonID)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer result.Close()
+
+	ranking := []*User{}
+	for result.Next() {
+		user := &User{}
+		if err := result.Scan(&user.UserID, &user.DisplayName, &user.Points, &user.LastExerciseDate); err != nil {
+			return nil, nil, err
+		}
+
+		ranking = append(ranking, user)
+	}
+
+	return season, ranking, result.Err()
+}
+
+// SeasonsEndpoint function that lists the archived seasons
+func SeasonsEndpoint(w http.ResponseWriter, r *http.Request) {
+	newResponse := &Response{}
+
+	database, err := openDatabase()
+	if err != nil {
+		response(w, http.StatusInternalServerError, newResponse, err)
+		return
+	}
+
+	seasons, err := getSeasons(database)
+	if err != nil {
+		response(w, http.StatusInternalServerError, newResponse, err)
+		return
+	}
+
+	newResponse.Seasons = seasons
+	response(w, http.StatusOK, newResponse, err)
+}
+
+// SeasonRankingEndpoint function that returns the frozen ranking of a season
+func SeasonRankingEndpoint(w http.ResponseWriter, r *http.Request) {
+	newResponse := &Response{}
+	params := mux.Vars(r)
+
+	if _, err := seasonWindow(params["seasonId"]); err != nil {
+		response(w, http.StatusBadRequest, newResponse, err)
+		return
+	}
+
+	database, err := openDatabase()
+	if err != nil {
+		response(w, http.StatusInternalServerError, newResponse, err)
+		return
+	}
+
+	season, ranking, err := getSnapshot(database, params["seasonId"])
+	if err == ErrSeasonNotFound {
+		response(w, http.StatusNotFound, newResponse, err)
+		return
+	}
+	if err != nil {
+		response(w, http.StatusInternalServerError, newResponse, err)
+		return
+	}
+
+	newResponse.Season = season
+	newResponse.Ranking = ranking
+	response(w, http.StatusOK, newResponse, err)
+}
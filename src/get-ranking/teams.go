@@ -0,0 +1,225 @@
+package rank
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Aggregation how the points of the members of a team are combined
+type Aggregation string
+
+var (
+	// ErrInvalidTeamIDs Error when teamIds params is invalid
+	ErrInvalidTeamIDs = errors.New("Invalid params teamIds")
+	// ErrInvalidAggregation Error when aggregation param is invalid
+	ErrInvalidAggregation = errors.New("Invalid aggregation must be SUM, AVERAGE or TOP")
+	// ErrInvalidTop Error when top param is not a positive number
+	ErrInvalidTop = errors.New("Invalid param top must be a positive number")
+
+	validAggregations = map[Aggregation]bool{
+		SumAggregation:     true,
+		AverageAggregation: true,
+		TopAggregation:     true,
+	}
+)
+
+const (
+	// SumAggregation team points are the sum of its members points
+	SumAggregation Aggregation = "SUM"
+	// AverageAggregation team points are the average of its members points
+	AverageAggregation Aggregation = "AVERAGE"
+	// TopAggregation team points are the sum of its best N members points
+	TopAggregation Aggregation = "TOP"
+
+	defaultTop = 3
+)
+
+// Team is a team struct
+type Team struct {
+	TeamID           string
+	Name             string
+	Points           float64
+	LastExerciseDate time.Time
+	Members          []*User
+}
+
+// ByTeamPoints implements sort.Interface based on the points field
+type ByTeamPoints []*Team
+
+func (p ByTeamPoints) Len() int { return len(p) }
+func (p ByTeamPoints) Less(i, j int) bool {
+	if p[i].Points == p[j].Points {
+		return p[i].LastExerciseDate.After(p[j].LastExerciseDate)
+	}
+
+	return p[i].Points > p[j].Points
+}
+func (p ByTeamPoints) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+
+func getTeams(database *sql.DB, teamIDs []string) ([]*Team, error) {
+	teams := []*Team{}
+
+	if len(teamIDs) == 0 {
+		result, err := database.Query(`SELECT ID FROM teams ORDER BY ID`)
+		if err != nil {
+			return nil, err
+		}
+
+		for result.Next() {
+			var teamID string
+			if err := result.Scan(&teamID); err != nil {
+				result.Close()
+				return nil, err
+			}
+
+			teamIDs = append(teamIDs, teamID)
+		}
+		result.Close()
+	}
+
+	for _, teamID := range teamIDs {
+		team := &Team{TeamID: teamID}
+		err := database.QueryRow(`SELECT NAME FROM teams WHERE ID=$1`, teamID).Scan(&team.Name)
+		if err == sql.ErrNoRows {
+			return nil, ErrInvalidTeamIDs
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		teams = append(teams, team)
+	}
+
+	return teams, nil
+}
+
+func getTeamMembers(database *sql.DB, teamID string) ([]string, error) {
+	result, err := database.Query(`SELECT USER_ID FROM team_members WHERE TEAM_ID=$1`, teamID)
+	if err != nil {
+		return nil, err
+	}
+	defer result.Close()
+
+	members := []string{}
+	for result.Next() {
+		var userID string
+		if err := result.Scan(&userID); err != nil {
+			return nil, err
+		}
+
+		members = append(members, userID)
+	}
+
+	return members, result.Err()
+}
+
+func aggregatePoints(team *Team, aggregation Aggregation, top int) {
+	sort.Sort(ByPoints(team.Members))
+
+	team.Points = 0
+	for i, member := range team.Members {
+		if aggregation == TopAggregation && i >= top {
+			break
+		}
+
+		team.Points += member.Points
+
+		if member.LastExerciseDate.After(team.LastExerciseDate) {
+			team.LastExerciseDate = member.LastExerciseDate
+		}
+	}
+
+	if aggregation == AverageAggregation && len(team.Members) > 0 {
+		team.Points /= float64(len(team.Members))
+	}
+}
+
+func getTotalPointsByTeam(teamIDs []string, aggregation Aggregation, top int, window Window) ([]*Team, error) {
+	database, err := openDatabase()
+	if err != nil {
+		return nil, err
+	}
+
+	teams, err := getTeams(database, teamIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, team := range teams {
+		members, err := getTeamMembers(database, team.TeamID)
+		if err != nil {
+			return nil, err
+		}
+
+		team.Members, err = getTotalPoints(members, window)
+		if err != nil {
+			return nil, err
+		}
+
+		aggregatePoints(team, aggregation, top)
+	}
+
+	return teams, nil
+}
+
+// TeamRankingEndpoint function that ranks teams by the points of their members
+func TeamRankingEndpoint(w http.ResponseWriter, r *http.Request) {
+	newResponse := &Response{}
+	query := r.URL.Query()
+
+	aggregation := SumAggregation
+	if query.Get("aggregation") != "" {
+		aggregation = Aggregation(query.Get("aggregation"))
+	}
+
+	if !validAggregations[aggregation] {
+		response(w, http.StatusBadRequest, newResponse, ErrInvalidAggregation)
+		return
+	}
+
+	top := defaultTop
+	if query.Get("top") != "" {
+		var err error
+		top, err = strconv.Atoi(query.Get("top"))
+		if err != nil || top < 1 {
+			response(w, http.StatusBadRequest, newResponse, ErrInvalidTop)
+			return
+		}
+	}
+
+	location, err := locationOf(r)
+	if err == ErrInvalidTimezone {
+		response(w, http.StatusBadRequest, newResponse, err)
+		return
+	}
+	if err != nil {
+		response(w, http.StatusInternalServerError, newResponse, err)
+		return
+	}
+
+	window := defaultWindow(time.Now(), location)
+	if window.Tag, err = tagOf(r); err != nil {
+		response(w, http.StatusBadRequest, newResponse, err)
+		return
+	}
+
+	totalPoints, err := getTotalPointsByTeam(query["teamIds"], aggregation, top, window)
+	if err == ErrInvalidTeamIDs {
+		response(w, http.StatusBadRequest, newResponse, err)
+		return
+	}
+	if err != nil {
+		response(w, http.StatusInternalServerError, newResponse, err)
+		return
+	}
+
+	sort.Sort(ByTeamPoints(totalPoints))
+
+	newResponse.Teams = totalPoints
+	newResponse.Window = &window
+	response(w, http.StatusOK, newResponse, err)
+}
@@ -6,16 +6,96 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"time"
 
+	admin "./admin-api"
+	auth "./authenticate-request"
 	create "./create-exercise"
+	remove "./delete-exercise"
+	heartrate "./exercise-heartrate"
+	history "./exercise-history"
+	records "./exercise-records"
+	export "./export-exercises"
+	get "./get-exercise"
 	rank "./get-ranking"
+	sets "./manage-sets"
+	tags "./manage-tags"
+	teams "./manage-teams"
+	users "./manage-users"
+	moderation "./moderate-exercises"
+	notify "./notify-users"
 	update "./update-exercise"
 
 	"github.com/gorilla/mux"
 	_ "github.com/mattn/go-sqlite3"
 )
 
-func createTable() error {
+// schema tables, and the rows they are seeded with, created on start up when missing
+var schema = []string{
+	"CREATE TABLE IF NOT EXISTS exercises (ID INTEGER PRIMARY KEY AUTOINCREMENT, USER_ID INTEGER NOT NULL, DESCRIPTION TEXT NOT NULL, TYPE TEXT NOT NULL, START_TIME DATE NOT NULL, FINISH_TIME DATE NOT NULL, DURATION INTEGER NOT NULL, CALORIES INTEGER NOT NULL)",
+	"CREATE TABLE IF NOT EXISTS users (ID INTEGER PRIMARY KEY AUTOINCREMENT, DISPLAY_NAME TEXT NOT NULL, TIMEZONE TEXT NOT NULL, WEIGHT REAL NOT NULL DEFAULT 0, BIRTH_YEAR INTEGER NOT NULL DEFAULT 0, ACTIVE INTEGER NOT NULL DEFAULT 1, CREATED_AT DATE NOT NULL)",
+	"CREATE TABLE IF NOT EXISTS seasons (ID TEXT PRIMARY KEY, START_DATE TEXT NOT NULL, END_DATE TEXT NOT NULL, ARCHIVED_AT DATE NOT NULL)",
+	"CREATE TABLE IF NOT EXISTS teams (ID INTEGER PRIMARY KEY AUTOINCREMENT, NAME TEXT NOT NULL, CREATED_AT DATE NOT NULL)",
+	"CREATE TABLE IF NOT EXISTS team_members (TEAM_ID INTEGER NOT NULL, USER_ID INTEGER NOT NULL, PRIMARY KEY (TEAM_ID, USER_ID))",
+	"CREATE TABLE IF NOT EXISTS snapshots (SEASON_ID TEXT NOT NULL, POSITION INTEGER NOT NULL, USER_ID INTEGER NOT NULL, POINTS REAL NOT NULL, LAST_EXERCISE_DATE DATE NOT NULL, PRIMARY KEY (SEASON_ID, POSITION))",
+	"CREATE TABLE IF NOT EXISTS exercise_types (TYPE TEXT PRIMARY KEY, MULTIPLICATION_FACTOR INTEGER NOT NULL)",
+	"INSERT OR IGNORE INTO exercise_types (TYPE, MULTIPLICATION_FACTOR) VALUES ('RUNNING', 2), ('SWIMMING', 3), ('STRENGTH_TRAINING', 3), ('CIRCUIT_TRAINING', 4)",
+	"CREATE TABLE IF NOT EXISTS exercise_audit (ID INTEGER PRIMARY KEY AUTOINCREMENT, EXERCISE_ID INTEGER NOT NULL, ACTION TEXT NOT NULL, ACTOR TEXT NOT NULL, CREATED_AT DATE NOT NULL, BEFORE TEXT, AFTER TEXT)",
+	"CREATE TRIGGER IF NOT EXISTS exercise_audit_no_update BEFORE UPDATE ON exercise_audit BEGIN SELECT RAISE(ABORT, 'exercise audit entries are immutable'); END",
+	"CREATE TRIGGER IF NOT EXISTS exercise_audit_no_delete BEFORE DELETE ON exercise_audit BEGIN SELECT RAISE(ABORT, 'exercise audit entries are immutable'); END",
+	"CREATE TABLE IF NOT EXISTS exercise_samples (EXERCISE_ID INTEGER NOT NULL, TIME DATE NOT NULL, LATITUDE REAL, LONGITUDE REAL, ALTITUDE REAL, DISTANCE REAL, HEART_RATE INTEGER)",
+	"CREATE INDEX IF NOT EXISTS exercise_samples_exercise ON exercise_samples (EXERCISE_ID, TIME)",
+	"CREATE TABLE IF NOT EXISTS exercise_laps (EXERCISE_ID INTEGER NOT NULL, POSITION INTEGER NOT NULL, START_TIME DATE NOT NULL, DURATION INTEGER NOT NULL, DISTANCE REAL NOT NULL, CALORIES INTEGER NOT NULL, PRIMARY KEY (EXERCISE_ID, POSITION))",
+	"CREATE TABLE IF NOT EXISTS exercise_sets (ID INTEGER PRIMARY KEY AUTOINCREMENT, EXERCISE_ID INTEGER NOT NULL, POSITION INTEGER NOT NULL, NAME TEXT NOT NULL, REPS INTEGER NOT NULL, WEIGHT REAL NOT NULL, REST INTEGER NOT NULL)",
+	"CREATE INDEX IF NOT EXISTS exercise_sets_exercise ON exercise_sets (EXERCISE_ID, POSITION)",
+	"CREATE TABLE IF NOT EXISTS exercise_circuits (EXERCISE_ID INTEGER PRIMARY KEY, ROUNDS_COMPLETED INTEGER NOT NULL)",
+	"CREATE TABLE IF NOT EXISTS circuit_stations (EXERCISE_ID INTEGER NOT NULL, POSITION INTEGER NOT NULL, NAME TEXT NOT NULL, WORK INTEGER NOT NULL, REST INTEGER NOT NULL, PRIMARY KEY (EXERCISE_ID, POSITION))",
+	"CREATE TABLE IF NOT EXISTS exercise_swims (EXERCISE_ID INTEGER PRIMARY KEY, POOL_LENGTH REAL NOT NULL, LAPS INTEGER NOT NULL, STROKE_COUNT INTEGER NOT NULL)",
+	"CREATE TABLE IF NOT EXISTS swim_strokes (EXERCISE_ID INTEGER NOT NULL, STROKE TEXT NOT NULL, DISTANCE REAL NOT NULL, PRIMARY KEY (EXERCISE_ID, STROKE))",
+	"CREATE TABLE IF NOT EXISTS exercise_tags (EXERCISE_ID INTEGER NOT NULL, TAG TEXT NOT NULL, PRIMARY KEY (EXERCISE_ID, TAG))",
+	"CREATE INDEX IF NOT EXISTS exercise_tags_tag ON exercise_tags (TAG)",
+	"CREATE TABLE IF NOT EXISTS exercise_heart_rates (EXERCISE_ID INTEGER PRIMARY KEY, FIRST_SAMPLE INTEGER NOT NULL, SAMPLES BLOB NOT NULL, MAX_HEART_RATE INTEGER NOT NULL, TRAINING_LOAD REAL NOT NULL)",
+	"CREATE TABLE IF NOT EXISTS exercise_reports (ID INTEGER PRIMARY KEY AUTOINCREMENT, EXERCISE_ID INTEGER NOT NULL, REPORTER TEXT NOT NULL, REASON TEXT NOT NULL, RESOLVED INTEGER NOT NULL, CREATED_AT DATE NOT NULL)",
+	"CREATE INDEX IF NOT EXISTS exercise_reports_exercise ON exercise_reports (EXERCISE_ID, RESOLVED)",
+	"CREATE TABLE IF NOT EXISTS exercise_reviews (ID INTEGER PRIMARY KEY AUTOINCREMENT, EXERCISE_ID INTEGER NOT NULL, DECISION TEXT NOT NULL, REASON TEXT NOT NULL, REVIEWER TEXT NOT NULL, CREATED_AT DATE NOT NULL)",
+	"CREATE TABLE IF NOT EXISTS notifications (ID INTEGER PRIMARY KEY AUTOINCREMENT, USER_ID INTEGER NOT NULL, KIND TEXT NOT NULL, EXERCISE_ID INTEGER NOT NULL, MESSAGE TEXT NOT NULL, CREATED_AT DATE NOT NULL)",
+	"CREATE INDEX IF NOT EXISTS notifications_user ON notifications (USER_ID)",
+	"CREATE TABLE IF NOT EXISTS personal_records (USER_ID INTEGER NOT NULL, TYPE TEXT NOT NULL, KIND TEXT NOT NULL, VALUE REAL NOT NULL, EXERCISE_ID INTEGER NOT NULL, ACHIEVED_AT DATE NOT NULL, PRIMARY KEY (USER_ID, TYPE, KIND))",
+	"CREATE TABLE IF NOT EXISTS idempotency_keys (KEY TEXT NOT NULL, PRINCIPAL TEXT NOT NULL, REQUEST_HASH TEXT NOT NULL, STATUS INTEGER NOT NULL, BODY TEXT NOT NULL, ETAG TEXT NOT NULL, CREATED_AT DATE NOT NULL, PRIMARY KEY (KEY, PRINCIPAL))",
+	"CREATE TABLE IF NOT EXISTS admin_audit (ID INTEGER PRIMARY KEY AUTOINCREMENT, ACTOR TEXT NOT NULL, ACTION TEXT NOT NULL, TARGET TEXT NOT NULL, DETAILS TEXT NOT NULL, CREATED_AT DATE NOT NULL)",
+}
+
+// migrations columns added to tables created by previous versions, they fail once applied, and rows backfilled into them
+var migrations = []string{
+	"ALTER TABLE exercises ADD COLUMN VERSION INTEGER NOT NULL DEFAULT 1",
+	"ALTER TABLE users ADD COLUMN CALENDAR_TOKEN TEXT",
+	"ALTER TABLE exercises ADD COLUMN DISTANCE REAL NOT NULL DEFAULT 0",
+	"ALTER TABLE exercise_types ADD COLUMN DISTANCE_FACTOR REAL NOT NULL DEFAULT 0",
+	"ALTER TABLE users ADD COLUMN MAX_HEART_RATE INTEGER NOT NULL DEFAULT 0",
+	"ALTER TABLE exercise_types ADD COLUMN LOAD_FACTOR REAL NOT NULL DEFAULT 0",
+	"ALTER TABLE exercises ADD COLUMN CALORIES_ESTIMATED INTEGER NOT NULL DEFAULT 0",
+	"ALTER TABLE exercises ADD COLUMN INTENSITY TEXT NOT NULL DEFAULT ''",
+	"ALTER TABLE exercise_types ADD COLUMN ESTIMATED_CALORIES_FACTOR REAL NOT NULL DEFAULT 0.5",
+	"ALTER TABLE exercises ADD COLUMN STATUS TEXT NOT NULL DEFAULT 'ACCEPTED'",
+	"ALTER TABLE exercises ADD COLUMN FLAG_REASON TEXT NOT NULL DEFAULT ''",
+	"ALTER TABLE exercise_types ADD COLUMN MAX_CALORIES_PER_MINUTE REAL NOT NULL DEFAULT 25",
+	"ALTER TABLE exercise_types ADD COLUMN MAX_DURATION INTEGER NOT NULL DEFAULT 86400",
+	"ALTER TABLE exercise_types ADD COLUMN PLAUSIBILITY_MODE TEXT NOT NULL DEFAULT 'FLAG'",
+	"ALTER TABLE exercises ADD COLUMN TIMEZONE TEXT NOT NULL DEFAULT 'UTC'",
+	"ALTER TABLE exercises ADD COLUMN NOTES TEXT NOT NULL DEFAULT ''",
+	"ALTER TABLE teams ADD COLUMN OWNER_ID INTEGER NOT NULL DEFAULT 0",
+	"ALTER TABLE exercise_types ADD COLUMN LOW_MET REAL",
+	"ALTER TABLE exercise_types ADD COLUMN MODERATE_MET REAL",
+	"ALTER TABLE exercise_types ADD COLUMN HIGH_MET REAL",
+	// metabolic equivalents of the built-in types from the Compendium of Physical Activities, set once
+	"UPDATE exercise_types SET LOW_MET=7.0, MODERATE_MET=9.8, HIGH_MET=11.8 WHERE TYPE='RUNNING' AND LOW_MET IS NULL",
+	"UPDATE exercise_types SET LOW_MET=5.8, MODERATE_MET=8.3, HIGH_MET=9.8 WHERE TYPE='SWIMMING' AND LOW_MET IS NULL",
+	"UPDATE exercise_types SET LOW_MET=3.5, MODERATE_MET=5.0, HIGH_MET=6.0 WHERE TYPE='STRENGTH_TRAINING' AND LOW_MET IS NULL",
+	"UPDATE exercise_types SET LOW_MET=4.3, MODERATE_MET=6.0, HIGH_MET=8.0 WHERE TYPE='CIRCUIT_TRAINING' AND LOW_MET IS NULL",
+	"INSERT OR IGNORE INTO users (ID, DISPLAY_NAME, TIMEZONE, ACTIVE, CREATED_AT) SELECT DISTINCT USER_ID, 'User ' || USER_ID, 'UTC', 1, strftime('%Y-%m-%d %H:%M:%S+00:00', 'now') FROM exercises",
+}
+
+func createTables() error {
 	dir, err := os.Getwd()
 	if err != nil {
 		return err
@@ -25,26 +105,122 @@ func createTable() error {
 		return err
 	}
 
-	statement, err := database.Prepare("CREATE TABLE IF NOT EXISTS exercises (ID INTEGER PRIMARY KEY AUTOINCREMENT, USER_ID INTEGER NOT NULL, DESCRIPTION TEXT NOT NULL, TYPE TEXT NOT NULL, START_TIME DATE NOT NULL, FINISH_TIME DATE NOT NULL, DURATION INTEGER NOT NULL, CALORIES INTEGER NOT NULL)")
+	for _, table := range schema {
+		statement, err := database.Prepare(table)
+		if err != nil {
+			return err
+		}
+
+		statement.Exec()
+	}
+
+	for _, migration := range migrations {
+		database.Exec(migration)
+	}
+
+	if err := normalizeTimes(database); err != nil {
+		return err
+	}
+
+	return records.Backfill(database)
+}
+
+// normalizeTimes rewrites in UTC the exercises stored with the offset they were received with
+func normalizeTimes(database *sql.DB) error {
+	type times struct {
+		id         int64
+		startTime  time.Time
+		finishTime time.Time
+	}
+
+	result, err := database.Query(`SELECT ID, START_TIME, FINISH_TIME FROM exercises WHERE START_TIME NOT LIKE '%+00:00' OR FINISH_TIME NOT LIKE '%+00:00'`)
 	if err != nil {
 		return err
 	}
 
-	statement.Exec()
+	offsets := []times{}
+	for result.Next() {
+		t := times{}
+		if err := result.Scan(&t.id, &t.startTime, &t.finishTime); err != nil {
+			result.Close()
+			return err
+		}
+
+		offsets = append(offsets, t)
+	}
+	result.Close()
+
+	for _, t := range offsets {
+		if _, err := database.Exec(`UPDATE exercises SET START_TIME=$1, FINISH_TIME=$2 WHERE ID=$3`, t.startTime.UTC(), t.finishTime.UTC(), t.id); err != nil {
+			return err
+		}
+	}
 
 	return nil
 }
 
 func main() {
-	err := createTable()
+	err := createTables()
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	go rank.SnapshotJob(time.Hour)
+
 	r := mux.NewRouter()
-	r.HandleFunc("/exercise", create.ExerciseEndpoint).Methods("POST")
-	r.HandleFunc("/exercise/{exerciseId}", update.ExerciseEndpoint).Methods("PUT")
-	r.HandleFunc("/ranking", rank.RankingEndpoint).Methods("GET")
+	// calendar apps can not send headers, the feed is authenticated by the token of its URL
+	r.HandleFunc("/users/{userId}/exercises.ics", users.CalendarFeedEndpoint).Methods("GET")
+
+	api := r.NewRoute().Subrouter()
+	api.Use(auth.Middleware)
+	api.HandleFunc("/exercise", create.Idempotent(create.ExerciseEndpoint)).Methods("POST")
+	api.HandleFunc("/exercises:batch", create.Idempotent(create.BatchEndpoint)).Methods("POST")
+	api.HandleFunc("/exercises/import", create.Idempotent(create.ImportEndpoint)).Methods("POST")
+	api.HandleFunc("/exercises/upload", create.Idempotent(create.UploadEndpoint)).Methods("POST")
+	api.HandleFunc("/exercises/export", export.ExportEndpoint).Methods("GET")
+	api.HandleFunc("/exercise/{exerciseId}", get.ExerciseEndpoint).Methods("GET")
+	api.HandleFunc("/exercise/{exerciseId}", update.ExerciseEndpoint).Methods("PUT")
+	api.HandleFunc("/exercise/{exerciseId}", update.PatchEndpoint).Methods("PATCH")
+	api.HandleFunc("/exercise/{exerciseId}", remove.ExerciseEndpoint).Methods("DELETE")
+	api.HandleFunc("/exercise/{exerciseId}/history", history.HistoryEndpoint).Methods("GET")
+	api.HandleFunc("/exercise/{exerciseId}/history/{entryId}/revert", history.RevertEndpoint).Methods("POST")
+	api.HandleFunc("/exercise/{exerciseId}/sets", sets.SetsEndpoint).Methods("GET")
+	api.HandleFunc("/exercise/{exerciseId}/sets", update.ReplaceSetsEndpoint).Methods("PUT")
+	api.HandleFunc("/exercise/{exerciseId}/sets", update.AddSetEndpoint).Methods("POST")
+	api.HandleFunc("/exercise/{exerciseId}/sets/{setId}", update.UpdateSetEndpoint).Methods("PUT")
+	api.HandleFunc("/exercise/{exerciseId}/sets/{setId}", update.DeleteSetEndpoint).Methods("DELETE")
+	api.HandleFunc("/exercise/{exerciseId}/heart-rate", heartrate.HeartRateEndpoint).Methods("GET")
+	api.HandleFunc("/exercise/{exerciseId}/heart-rate", heartrate.AttachHeartRateEndpoint).Methods("PUT")
+	api.HandleFunc("/exercise/{exerciseId}/reports", moderation.ReportEndpoint).Methods("POST")
+	api.HandleFunc("/ranking", rank.RankingEndpoint).Methods("GET")
+	api.HandleFunc("/ranking/teams", rank.TeamRankingEndpoint).Methods("GET")
+	api.HandleFunc("/users", users.UserEndpoint).Methods("POST")
+	api.HandleFunc("/users/{userId}", users.GetUserEndpoint).Methods("GET")
+	api.HandleFunc("/users/{userId}", users.UpdateUserEndpoint).Methods("PUT")
+	api.HandleFunc("/users/{userId}", users.DeactivateUserEndpoint).Methods("DELETE")
+	api.HandleFunc("/users/{userId}/calendar", users.CalendarEndpoint).Methods("GET", "POST")
+	api.HandleFunc("/users/{userId}/notifications", notify.NotificationsEndpoint).Methods("GET")
+	api.HandleFunc("/users/{userId}/tags", tags.UserTagsEndpoint).Methods("GET")
+	api.HandleFunc("/users/{userId}/records", records.RecordsEndpoint).Methods("GET")
+	api.HandleFunc("/teams", teams.TeamEndpoint).Methods("POST")
+	api.HandleFunc("/teams/{teamId}", teams.GetTeamEndpoint).Methods("GET")
+	api.HandleFunc("/teams/{teamId}/members", teams.AddMemberEndpoint).Methods("POST")
+	api.HandleFunc("/teams/{teamId}/members/{userId}", teams.RemoveMemberEndpoint).Methods("DELETE")
+	api.HandleFunc("/seasons", rank.SeasonsEndpoint).Methods("GET")
+	api.HandleFunc("/seasons/{seasonId}/ranking", rank.SeasonRankingEndpoint).Methods("GET")
+
+	adminRouter := api.PathPrefix("/admin").Subrouter()
+	adminRouter.Use(auth.RequireRole(auth.AdminRole))
+	adminRouter.HandleFunc("/exercise-types", admin.ExerciseTypesEndpoint).Methods("GET")
+	adminRouter.HandleFunc("/exercise-types/{type}", admin.SaveExerciseTypeEndpoint).Methods("PUT")
+	adminRouter.HandleFunc("/exercise-types/{type}", admin.DeleteExerciseTypeEndpoint).Methods("DELETE")
+	adminRouter.HandleFunc("/moderation", moderation.QueueEndpoint).Methods("GET")
+	adminRouter.HandleFunc("/moderation/{exerciseId}/approve", moderation.ApproveEndpoint).Methods("POST")
+	adminRouter.HandleFunc("/moderation/{exerciseId}/reject", moderation.RejectEndpoint).Methods("POST")
+	adminRouter.HandleFunc("/seasons/{seasonId}/recompute", admin.RecomputeRankingEndpoint).Methods("POST")
+	adminRouter.HandleFunc("/users/merge", admin.MergeUsersEndpoint).Methods("POST")
+	adminRouter.HandleFunc("/users/{userId}/exercises", admin.DeleteUserExercisesEndpoint).Methods("DELETE")
+	adminRouter.HandleFunc("/audit", admin.AuditEndpoint).Methods("GET")
 
 	log.Fatal(http.ListenAndServe(":8080", r))
 }
@@ -0,0 +1,212 @@
+package circuits
+
+import (
+	"database/sql"
+	"errors"
+
+	text "../exercise-text"
+)
+
+var (
+	// ErrUnwantedCircuit Error when a circuit is received for an exercise that is not circuit training
+	ErrUnwantedCircuit = errors.New("Circuits can only be recorded for CIRCUIT_TRAINING exercises")
+	// ErrMissingStations Error when the circuit has no stations
+	ErrMissingStations = errors.New("Missing circuit stations")
+	// ErrTooManyStations Error when the circuit has more stations than allowed
+	ErrTooManyStations = errors.New("A circuit can not have more than 50 stations")
+	// ErrMissingStationName Error when name field of a station is not received
+	ErrMissingStationName = errors.New("Missing station name")
+	// ErrInvalidStationName Error when name field of a station has characters other than letters, numbers and spaces
+	ErrInvalidStationName = errors.New("Invalid station name must only have letters, numbers and spaces")
+	// ErrInvalidWork Error when work field of a station is out of range
+	ErrInvalidWork = errors.New("Invalid work must be between 1 and 3600 seconds")
+	// ErrInvalidRest Error when rest field of a station is out of range
+	ErrInvalidRest = errors.New("Invalid rest must be between 0 and 3600 seconds")
+	// ErrInvalidRoundsCompleted Error when roundsCompleted field is out of range
+	ErrInvalidRoundsCompleted = errors.New("Invalid roundsCompleted must be between 1 and 100")
+	// ErrDurationMismatch Error when the intervals of the circuit do not add up to the duration of the exercise
+	ErrDurationMismatch = errors.New("The work and rest of every round of the circuit must add up to the duration of the exercise")
+)
+
+const (
+	circuitTrainingType = "CIRCUIT_TRAINING"
+
+	maxStations = 50
+	maxSeconds  = 3600
+	maxRounds   = 100
+)
+
+// Station exercise of a circuit performed for a time and followed by a rest
+type Station struct {
+	// Name of the station, e.g. Burpees
+	Name string `json:"name"`
+	// Work seconds working on the station
+	Work int64 `json:"work"`
+	// Rest seconds rested before the next station
+	Rest int64 `json:"rest"`
+}
+
+// Round pass through every station of the circuit
+type Round struct {
+	// Round number, starting on 1
+	Round int64 `json:"round"`
+	// Duration seconds of work and rest of the round
+	Duration int64 `json:"duration"`
+	// Intervals stations of the round in order
+	Intervals []*Station `json:"intervals"`
+}
+
+// Circuit stations of a circuit training exercise and how many times they were gone through
+type Circuit struct {
+	// Stations in the order they are performed on every round
+	Stations []*Station `json:"stations"`
+	// RoundsCompleted times every station was performed
+	RoundsCompleted int64 `json:"roundsCompleted"`
+	// Rounds detail of every round, ignored on requests
+	Rounds []*Round `json:"rounds,omitempty"`
+}
+
+// Queryer database or transaction the circuits are read from and written to
+type Queryer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+func (s *Station) validate() error {
+	name, ok := text.Name(s.Name)
+	if name == "" {
+		return ErrMissingStationName
+	}
+
+	if !ok {
+		return ErrInvalidStationName
+	}
+	s.Name = name
+
+	if s.Work < 1 || s.Work > maxSeconds {
+		return ErrInvalidWork
+	}
+
+	if s.Rest < 0 || s.Rest > maxSeconds {
+		return ErrInvalidRest
+	}
+
+	return nil
+}
+
+// roundDuration seconds of work and rest of a pass through every station
+func (c *Circuit) roundDuration() int64 {
+	var duration int64
+	for _, station := range c.Stations {
+		duration += station.Work + station.Rest
+	}
+
+	return duration
+}
+
+// Validate checks the circuit of an exercise of the given type adds up to its duration
+func Validate(exerciseType string, duration int64, circuit *Circuit) error {
+	if circuit == nil {
+		return nil
+	}
+
+	if exerciseType != circuitTrainingType {
+		return ErrUnwantedCircuit
+	}
+
+	if len(circuit.Stations) == 0 {
+		return ErrMissingStations
+	}
+
+	if len(circuit.Stations) > maxStations {
+		return ErrTooManyStations
+	}
+
+	for _, station := range circuit.Stations {
+		if err := station.validate(); err != nil {
+			return err
+		}
+	}
+
+	if circuit.RoundsCompleted < 1 || circuit.RoundsCompleted > maxRounds {
+		return ErrInvalidRoundsCompleted
+	}
+
+	if circuit.roundDuration()*circuit.RoundsCompleted != duration {
+		return ErrDurationMismatch
+	}
+
+	return nil
+}
+
+// Expand fills the detail of every round of the circuit
+func (c *Circuit) Expand() *Circuit {
+	c.Rounds = []*Round{}
+	for round := int64(1); round <= c.RoundsCompleted; round++ {
+		c.Rounds = append(c.Rounds, &Round{Round: round, Duration: c.roundDuration(), Intervals: c.Stations})
+	}
+
+	return c
+}
+
+// Save stores the circuit of an exercise, replacing the previous one
+func Save(database Queryer, exerciseID int64, circuit *Circuit) error {
+	if _, err := database.Exec(`DELETE FROM circuit_stations WHERE EXERCISE_ID=$1`, exerciseID); err != nil {
+		return err
+	}
+
+	if _, err := database.Exec(`DELETE FROM exercise_circuits WHERE EXERCISE_ID=$1`, exerciseID); err != nil {
+		return err
+	}
+
+	if circuit == nil {
+		return nil
+	}
+
+	if _, err := database.Exec(`INSERT INTO exercise_circuits (EXERCISE_ID, ROUNDS_COMPLETED) VALUES ($1, $2)`, exerciseID, circuit.RoundsCompleted); err != nil {
+		return err
+	}
+
+	for position, station := range circuit.Stations {
+		_, err := database.Exec(`INSERT INTO circuit_stations (EXERCISE_ID, POSITION, NAME, WORK, REST) VALUES ($1, $2, $3, $4, $5)`, exerciseID, position, station.Name, station.Work, station.Rest)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Load circuit of an exercise with the detail of every round, nil when it has none
+func Load(database Queryer, exerciseID int64) (*Circuit, error) {
+	circuit := &Circuit{}
+	err := database.QueryRow(`SELECT ROUNDS_COMPLETED FROM exercise_circuits WHERE EXERCISE_ID=$1`, exerciseID).Scan(&circuit.RoundsCompleted)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := database.Query(`SELECT NAME, WORK, REST FROM circuit_stations WHERE EXERCISE_ID=$1 ORDER BY POSITION`, exerciseID)
+	if err != nil {
+		return nil, err
+	}
+	defer result.Close()
+
+	for result.Next() {
+		station := &Station{}
+		if err := result.Scan(&station.Name, &station.Work, &station.Rest); err != nil {
+			return nil, err
+		}
+
+		circuit.Stations = append(circuit.Stations, station)
+	}
+
+	if err := result.Err(); err != nil {
+		return nil, err
+	}
+
+	return circuit.Expand(), nil
+}
@@ -0,0 +1,341 @@
+package sets
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+
+	auth "../authenticate-request"
+	text "../exercise-text"
+	"github.com/gorilla/mux"
+)
+
+var (
+	// ErrInvalidID Error when exercise or set id is not valid
+	ErrInvalidID = errors.New("Invalid id")
+	// ErrNoExerciseFound The exercise does not exists
+	ErrNoExerciseFound = errors.New("The exercise you requested does not exists")
+	// ErrNoSetFound The set does not exists
+	ErrNoSetFound = errors.New("The set you requested does not exists")
+	// ErrUnwantedSets Error when sets are received for an exercise that is not strength training
+	ErrUnwantedSets = errors.New("Sets can only be recorded for STRENGTH_TRAINING exercises")
+	// ErrTooManySets Error when an exercise has more sets than allowed
+	ErrTooManySets = errors.New("An exercise can not have more than 100 sets")
+	// ErrMissingName Error when name field is not received
+	ErrMissingName = errors.New("Missing set name")
+	// ErrInvalidName Error when name field has characters other than letters, numbers and spaces
+	ErrInvalidName = errors.New("Invalid set name must only have letters, numbers and spaces")
+	// ErrInvalidReps Error when reps field is out of range
+	ErrInvalidReps = errors.New("Invalid reps must be between 1 and 1000")
+	// ErrInvalidWeight Error when weight field is out of range
+	ErrInvalidWeight = errors.New("Invalid weight must be between 0 and 1000 kilograms")
+	// ErrInvalidRest Error when rest field is out of range
+	ErrInvalidRest = errors.New("Invalid rest must be between 0 and 3600 seconds")
+)
+
+const (
+	strengthTrainingType = "STRENGTH_TRAINING"
+
+	maxSets   = 100
+	maxReps   = 1000
+	maxWeight = 1000
+	maxRest   = 3600
+)
+
+// Set series of repetitions of a strength training exercise
+type Set struct {
+	// ID field of Set
+	ID int64 `json:"id"`
+	// Name of the lift or movement, e.g. Bench press
+	Name string `json:"name"`
+	// Reps repetitions performed
+	Reps int64 `json:"reps"`
+	// Weight lifted on every repetition, in kilograms
+	Weight float64 `json:"weight"`
+	// Rest seconds rested after the set
+	Rest int64 `json:"rest"`
+}
+
+// Queryer database or transaction the sets are read from and written to
+type Queryer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// Response for /exercise/{exerciseId}/sets
+type Response struct {
+	Sets   []*Set  `json:"sets,omitempty"`
+	Set    *Set    `json:"set,omitempty"`
+	Volume float64 `json:"volume,omitempty"`
+	Error  string  `json:"error,omitempty"`
+}
+
+func openDatabase() (*sql.DB, error) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+
+	return sql.Open("sqlite3", fmt.Sprintf("%s/egym.db", dir))
+}
+
+// Validate checks the values of a set
+func (s *Set) Validate() error {
+	name, ok := text.Name(s.Name)
+	if name == "" {
+		return ErrMissingName
+	}
+
+	if !ok {
+		return ErrInvalidName
+	}
+	s.Name = name
+
+	if s.Reps < 1 || s.Reps > maxReps {
+		return ErrInvalidReps
+	}
+
+	if s.Weight < 0 || s.Weight > maxWeight {
+		return ErrInvalidWeight
+	}
+
+	if s.Rest < 0 || s.Rest > maxRest {
+		return ErrInvalidRest
+	}
+
+	return nil
+}
+
+// Validate checks the sets of an exercise of the given type
+func Validate(exerciseType string, sets []*Set) error {
+	if len(sets) == 0 {
+		return nil
+	}
+
+	if exerciseType != strengthTrainingType {
+		return ErrUnwantedSets
+	}
+
+	if len(sets) > maxSets {
+		return ErrTooManySets
+	}
+
+	for _, set := range sets {
+		if err := set.Validate(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Volume total kilograms lifted, reps times weight of every set
+func Volume(sets []*Set) float64 {
+	volume := 0.0
+	for _, set := range sets {
+		volume += float64(set.Reps) * set.Weight
+	}
+
+	return volume
+}
+
+func insertSet(database Queryer, exerciseID int64, set *Set) error {
+	sqlStatement := `INSERT INTO exercise_sets (EXERCISE_ID, POSITION, NAME, REPS, WEIGHT, REST) VALUES ($1, (SELECT COALESCE(MAX(POSITION), 0) + 1 FROM exercise_sets WHERE EXERCISE_ID=$2), $3, $4, $5, $6)`
+	result, err := database.Exec(sqlStatement, exerciseID, exerciseID, set.Name, set.Reps, set.Weight, set.Rest)
+	if err != nil {
+		return err
+	}
+
+	set.ID, err = result.LastInsertId()
+
+	return err
+}
+
+// Insert appends the sets to the exercise, in the order received
+func Insert(database Queryer, exerciseID int64, sets []*Set) error {
+	for _, set := range sets {
+		if err := insertSet(database, exerciseID, set); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Load sets of an exercise in the order they were performed
+func Load(database Queryer, exerciseID int64) ([]*Set, error) {
+	result, err := database.Query(`SELECT ID, NAME, REPS, WEIGHT, REST FROM exercise_sets WHERE EXERCISE_ID=$1 ORDER BY POSITION`, exerciseID)
+	if err != nil {
+		return nil, err
+	}
+	defer result.Close()
+
+	sets := []*Set{}
+	for result.Next() {
+		set := &Set{}
+		if err := result.Scan(&set.ID, &set.Name, &set.Reps, &set.Weight, &set.Rest); err != nil {
+			return nil, err
+		}
+
+		sets = append(sets, set)
+	}
+
+	return sets, result.Err()
+}
+
+// Replace every set of the exercise with the ones received
+func Replace(database Queryer, exerciseID int64, sets []*Set) error {
+	if _, err := database.Exec(`DELETE FROM exercise_sets WHERE EXERCISE_ID=$1`, exerciseID); err != nil {
+		return err
+	}
+
+	return Insert(database, exerciseID, sets)
+}
+
+// Append a set after the last one of the exercise
+func Append(database Queryer, exerciseID int64, set *Set) error {
+	var totalSets int
+	if err := database.QueryRow(`SELECT COUNT(*) FROM exercise_sets WHERE EXERCISE_ID=$1`, exerciseID).Scan(&totalSets); err != nil {
+		return err
+	}
+
+	if totalSets >= maxSets {
+		return ErrTooManySets
+	}
+
+	return insertSet(database, exerciseID, set)
+}
+
+// Update a set of the exercise
+func Update(database Queryer, exerciseID int64, set *Set) error {
+	result, err := database.Exec(`UPDATE exercise_sets SET NAME=$1, REPS=$2, WEIGHT=$3, REST=$4 WHERE ID=$5 AND EXERCISE_ID=$6`, set.Name, set.Reps, set.Weight, set.Rest, set.ID, exerciseID)
+	if err != nil {
+		return err
+	}
+
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if updated == 0 {
+		return ErrNoSetFound
+	}
+
+	return nil
+}
+
+// Delete a set of the exercise
+func Delete(database Queryer, exerciseID int64, setID int64) error {
+	result, err := database.Exec(`DELETE FROM exercise_sets WHERE ID=$1 AND EXERCISE_ID=$2`, setID, exerciseID)
+	if err != nil {
+		return err
+	}
+
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if deleted == 0 {
+		return ErrNoSetFound
+	}
+
+	return nil
+}
+
+func getExerciseType(database *sql.DB, exerciseID int64) (int64, string, error) {
+	var userID int64
+	var exerciseType string
+
+	err := database.QueryRow(`SELECT USER_ID, TYPE FROM exercises WHERE ID=$1`, exerciseID).Scan(&userID, &exerciseType)
+	if err == sql.ErrNoRows {
+		return 0, "", ErrNoExerciseFound
+	}
+
+	return userID, exerciseType, err
+}
+
+func response(w http.ResponseWriter, httpStatus int, response *Response, err error) {
+	if err != nil {
+		response.Error = err.Error()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(httpStatus)
+	json.NewEncoder(w).Encode(response)
+}
+
+// StatusOf http status of the errors of the sets
+func StatusOf(err error) int {
+	switch err {
+	case ErrNoExerciseFound, ErrNoSetFound:
+		return http.StatusNotFound
+	case ErrUnwantedSets:
+		return http.StatusConflict
+	case ErrInvalidID, ErrTooManySets, ErrMissingName, ErrInvalidName, ErrInvalidReps, ErrInvalidWeight, ErrInvalidRest:
+		return http.StatusBadRequest
+	case auth.ErrForbidden:
+		return http.StatusForbidden
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// authorizedExercise opens the database and checks the principal owns the strength training exercise of the request
+func authorizedExercise(r *http.Request) (*sql.DB, int64, error) {
+	exerciseID, err := strconv.ParseInt(mux.Vars(r)["exerciseId"], 10, 64)
+	if err != nil {
+		return nil, 0, ErrInvalidID
+	}
+
+	database, err := openDatabase()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	userID, exerciseType, err := getExerciseType(database, exerciseID)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if !auth.FromRequest(r).CanActFor(userID) {
+		return nil, 0, auth.ErrForbidden
+	}
+
+	if exerciseType != strengthTrainingType {
+		return nil, 0, ErrUnwantedSets
+	}
+
+	return database, exerciseID, nil
+}
+
+func respondSets(w http.ResponseWriter, database *sql.DB, exerciseID int64, httpStatus int, newResponse *Response) {
+	sets, err := Load(database, exerciseID)
+	if err != nil {
+		response(w, http.StatusInternalServerError, newResponse, err)
+		return
+	}
+
+	newResponse.Sets = sets
+	newResponse.Volume = Volume(sets)
+	response(w, httpStatus, newResponse, err)
+}
+
+// SetsEndpoint function that returns the sets of an exercise and its volume
+func SetsEndpoint(w http.ResponseWriter, r *http.Request) {
+	newResponse := &Response{}
+
+	database, exerciseID, err := authorizedExercise(r)
+	if err != nil {
+		response(w, StatusOf(err), newResponse, err)
+		return
+	}
+
+	respondSets(w, database, exerciseID, http.StatusOK, newResponse)
+}
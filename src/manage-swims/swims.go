@@ -0,0 +1,234 @@
+package swims
+
+import (
+	"database/sql"
+	"errors"
+	"math"
+)
+
+// Stroke swimming style
+type Stroke string
+
+var (
+	// ErrUnwantedSwim Error when a swim is received for an exercise that is not swimming
+	ErrUnwantedSwim = errors.New("Pool length, laps and strokes can only be recorded for SWIMMING exercises")
+	// ErrInvalidPoolLength Error when poolLength field is out of range
+	ErrInvalidPoolLength = errors.New("Invalid poolLength must be between 10 and 100 meters")
+	// ErrInvalidLaps Error when laps field is out of range
+	ErrInvalidLaps = errors.New("Invalid laps must be between 1 and 10000")
+	// ErrInvalidStrokeCount Error when strokeCount field is negative
+	ErrInvalidStrokeCount = errors.New("Invalid strokeCount must not be negative")
+	// ErrInvalidStroke Error when stroke field is not a known style
+	ErrInvalidStroke = errors.New("Invalid stroke must be FREESTYLE, BACKSTROKE, BREASTSTROKE, BUTTERFLY or MEDLEY")
+	// ErrDuplicatedStroke Error when a stroke is received twice
+	ErrDuplicatedStroke = errors.New("Duplicated stroke")
+	// ErrInvalidStrokeDistance Error when the distance of a stroke is not positive
+	ErrInvalidStrokeDistance = errors.New("Invalid stroke distance must be a positive number of meters")
+	// ErrStrokesMismatch Error when the distances of the strokes do not add up to the laps swum
+	ErrStrokesMismatch = errors.New("The distances of the strokes must add up to poolLength times laps")
+	// ErrDistanceMismatch Error when the distance of the exercise is not the one swum on the laps
+	ErrDistanceMismatch = errors.New("The distance of the exercise must be poolLength times laps")
+)
+
+const (
+	// FreestyleStroke front crawl
+	FreestyleStroke Stroke = "FREESTYLE"
+	// BackstrokeStroke back crawl
+	BackstrokeStroke Stroke = "BACKSTROKE"
+	// BreaststrokeStroke breaststroke
+	BreaststrokeStroke Stroke = "BREASTSTROKE"
+	// ButterflyStroke butterfly
+	ButterflyStroke Stroke = "BUTTERFLY"
+	// MedleyStroke every style in turns
+	MedleyStroke Stroke = "MEDLEY"
+
+	swimmingType = "SWIMMING"
+
+	minPoolLength = 10
+	maxPoolLength = 100
+	maxLaps       = 10000
+)
+
+var validStrokes = map[Stroke]bool{
+	FreestyleStroke:    true,
+	BackstrokeStroke:   true,
+	BreaststrokeStroke: true,
+	ButterflyStroke:    true,
+	MedleyStroke:       true,
+}
+
+// StrokeDistance meters swum in a style
+type StrokeDistance struct {
+	Stroke   Stroke  `json:"stroke"`
+	Distance float64 `json:"distance"`
+}
+
+// Swim laps of a pool swimming exercise
+type Swim struct {
+	// PoolLength meters of a length of the pool
+	PoolLength float64 `json:"poolLength"`
+	// Laps lengths of the pool swum
+	Laps int64 `json:"laps"`
+	// StrokeCount arm strokes taken on the whole swim, used for SWOLF
+	StrokeCount int64 `json:"strokeCount,omitempty"`
+	// Strokes meters swum in every style
+	Strokes []*StrokeDistance `json:"strokes,omitempty"`
+	// TotalDistance meters swum, derived
+	TotalDistance float64 `json:"totalDistance"`
+	// SecondsPerLap average time of a lap, derived
+	SecondsPerLap float64 `json:"secondsPerLap"`
+	// StrokesPerLap average strokes of a lap, derived
+	StrokesPerLap float64 `json:"strokesPerLap,omitempty"`
+	// Swolf seconds plus strokes of an average lap, the lower the more efficient, derived
+	Swolf float64 `json:"swolf,omitempty"`
+}
+
+// Queryer database or transaction the swims are read from and written to
+type Queryer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// Distance meters swum on the laps
+func (s *Swim) Distance() float64 {
+	return s.PoolLength * float64(s.Laps)
+}
+
+func round(value float64) float64 {
+	return math.Round(value*100) / 100
+}
+
+// Validate checks the swim of an exercise of the given type matches its distance
+func Validate(exerciseType string, distance float64, swim *Swim) error {
+	if swim == nil {
+		return nil
+	}
+
+	if exerciseType != swimmingType {
+		return ErrUnwantedSwim
+	}
+
+	if swim.PoolLength < minPoolLength || swim.PoolLength > maxPoolLength {
+		return ErrInvalidPoolLength
+	}
+
+	if swim.Laps < 1 || swim.Laps > maxLaps {
+		return ErrInvalidLaps
+	}
+
+	if swim.StrokeCount < 0 {
+		return ErrInvalidStrokeCount
+	}
+
+	seen := map[Stroke]bool{}
+	strokesDistance := 0.0
+	for _, stroke := range swim.Strokes {
+		if !validStrokes[stroke.Stroke] {
+			return ErrInvalidStroke
+		}
+
+		if seen[stroke.Stroke] {
+			return ErrDuplicatedStroke
+		}
+
+		if stroke.Distance <= 0 {
+			return ErrInvalidStrokeDistance
+		}
+
+		seen[stroke.Stroke] = true
+		strokesDistance += stroke.Distance
+	}
+
+	if len(swim.Strokes) > 0 && round(strokesDistance) != round(swim.Distance()) {
+		return ErrStrokesMismatch
+	}
+
+	if distance != 0 && round(distance) != round(swim.Distance()) {
+		return ErrDistanceMismatch
+	}
+
+	return nil
+}
+
+// Derive fills the efficiency metrics of the swim for the duration of the exercise
+func (s *Swim) Derive(duration int64) *Swim {
+	s.TotalDistance = round(s.Distance())
+	s.SecondsPerLap = 0
+	s.StrokesPerLap = 0
+	s.Swolf = 0
+
+	if s.Laps == 0 {
+		return s
+	}
+
+	s.SecondsPerLap = round(float64(duration) / float64(s.Laps))
+	if s.StrokeCount > 0 {
+		s.StrokesPerLap = round(float64(s.StrokeCount) / float64(s.Laps))
+		s.Swolf = round(s.SecondsPerLap + s.StrokesPerLap)
+	}
+
+	return s
+}
+
+// Save stores the swim of an exercise, replacing the previous one
+func Save(database Queryer, exerciseID int64, swim *Swim) error {
+	if _, err := database.Exec(`DELETE FROM swim_strokes WHERE EXERCISE_ID=$1`, exerciseID); err != nil {
+		return err
+	}
+
+	if _, err := database.Exec(`DELETE FROM exercise_swims WHERE EXERCISE_ID=$1`, exerciseID); err != nil {
+		return err
+	}
+
+	if swim == nil {
+		return nil
+	}
+
+	_, err := database.Exec(`INSERT INTO exercise_swims (EXERCISE_ID, POOL_LENGTH, LAPS, STROKE_COUNT) VALUES ($1, $2, $3, $4)`, exerciseID, swim.PoolLength, swim.Laps, swim.StrokeCount)
+	if err != nil {
+		return err
+	}
+
+	for _, stroke := range swim.Strokes {
+		_, err := database.Exec(`INSERT INTO swim_strokes (EXERCISE_ID, STROKE, DISTANCE) VALUES ($1, $2, $3)`, exerciseID, stroke.Stroke, stroke.Distance)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Load swim of an exercise with its derived metrics, nil when it has none
+func Load(database Queryer, exerciseID int64, duration int64) (*Swim, error) {
+	swim := &Swim{}
+	err := database.QueryRow(`SELECT POOL_LENGTH, LAPS, STROKE_COUNT FROM exercise_swims WHERE EXERCISE_ID=$1`, exerciseID).Scan(&swim.PoolLength, &swim.Laps, &swim.StrokeCount)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := database.Query(`SELECT STROKE, DISTANCE FROM swim_strokes WHERE EXERCISE_ID=$1 ORDER BY STROKE`, exerciseID)
+	if err != nil {
+		return nil, err
+	}
+	defer result.Close()
+
+	for result.Next() {
+		stroke := &StrokeDistance{}
+		if err := result.Scan(&stroke.Stroke, &stroke.Distance); err != nil {
+			return nil, err
+		}
+
+		swim.Strokes = append(swim.Strokes, stroke)
+	}
+
+	if err := result.Err(); err != nil {
+		return nil, err
+	}
+
+	return swim.Derive(duration), nil
+}
@@ -0,0 +1,207 @@
+package tags
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	auth "../authenticate-request"
+	text "../exercise-text"
+	"github.com/gorilla/mux"
+)
+
+var (
+	// ErrInvalidUserID Error when user id is not valid
+	ErrInvalidUserID = errors.New("Invalid user id")
+	// ErrInvalidTag Error when a tag has characters other than letters, numbers, hyphens and underscores
+	ErrInvalidTag = errors.New("Invalid tag must only have letters, numbers, hyphens and underscores")
+	// ErrTagTooLong Error when a tag is longer than allowed
+	ErrTagTooLong = errors.New("Invalid tag must not be longer than 30 characters")
+	// ErrTooManyTags Error when more tags than allowed are received for an exercise
+	ErrTooManyTags = errors.New("Invalid tags an exercise can not have more than 10")
+)
+
+const (
+	maxTagLength = 30
+	maxTags      = 10
+)
+
+// Usage of a tag on the exercises of a user
+type Usage struct {
+	Tag       string `json:"tag"`
+	Exercises int64  `json:"exercises"`
+}
+
+// Queryer database or transaction the tags are read from and written to
+type Queryer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// Response for /users/{userId}/tags
+type Response struct {
+	Tags  []*Usage `json:"tags,omitempty"`
+	Error string   `json:"error,omitempty"`
+}
+
+func openDatabase() (*sql.DB, error) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+
+	return sql.Open("sqlite3", fmt.Sprintf("%s/egym.db", dir))
+}
+
+func isTagRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsMark(r) || unicode.IsNumber(r) || r == '-' || r == '_'
+}
+
+// Normalize a tag so it is matched regardless of its case or how its accents were composed
+func Normalize(tag string) (string, error) {
+	tag = strings.ToLower(text.Normalize(strings.TrimSpace(tag)))
+	if tag == "" || strings.IndexFunc(tag, func(r rune) bool { return !isTagRune(r) }) != -1 {
+		return "", ErrInvalidTag
+	}
+
+	if utf8.RuneCountInString(tag) > maxTagLength {
+		return "", ErrTagTooLong
+	}
+
+	return tag, nil
+}
+
+// Validate tags of an exercise, returning them normalized, sorted and without duplicates
+func Validate(tags []string) ([]string, error) {
+	if tags == nil {
+		return nil, nil
+	}
+
+	unique := map[string]bool{}
+	normalized := []string{}
+	for _, tag := range tags {
+		tag, err := Normalize(tag)
+		if err != nil {
+			return nil, err
+		}
+
+		if !unique[tag] {
+			unique[tag] = true
+			normalized = append(normalized, tag)
+		}
+	}
+
+	if len(normalized) > maxTags {
+		return nil, ErrTooManyTags
+	}
+
+	sort.Strings(normalized)
+
+	return normalized, nil
+}
+
+// Save stores the tags of an exercise, replacing the previous ones
+func Save(database Queryer, exerciseID int64, tags []string) error {
+	if _, err := database.Exec(`DELETE FROM exercise_tags WHERE EXERCISE_ID=$1`, exerciseID); err != nil {
+		return err
+	}
+
+	for _, tag := range tags {
+		if _, err := database.Exec(`INSERT INTO exercise_tags (EXERCISE_ID, TAG) VALUES ($1, $2)`, exerciseID, tag); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Load tags of an exercise, nil when it has none
+func Load(database Queryer, exerciseID int64) ([]string, error) {
+	result, err := database.Query(`SELECT TAG FROM exercise_tags WHERE EXERCISE_ID=$1 ORDER BY TAG`, exerciseID)
+	if err != nil {
+		return nil, err
+	}
+	defer result.Close()
+
+	var tags []string
+	for result.Next() {
+		var tag string
+		if err := result.Scan(&tag); err != nil {
+			return nil, err
+		}
+
+		tags = append(tags, tag)
+	}
+
+	return tags, result.Err()
+}
+
+func getUsages(database *sql.DB, userID int64) ([]*Usage, error) {
+	result, err := database.Query(`SELECT t.TAG, COUNT(*) FROM exercise_tags t JOIN exercises e ON e.ID = t.EXERCISE_ID WHERE e.USER_ID=$1 GROUP BY t.TAG ORDER BY COUNT(*) DESC, t.TAG`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer result.Close()
+
+	usages := []*Usage{}
+	for result.Next() {
+		usage := &Usage{}
+		if err := result.Scan(&usage.Tag, &usage.Exercises); err != nil {
+			return nil, err
+		}
+
+		usages = append(usages, usage)
+	}
+
+	return usages, result.Err()
+}
+
+func response(w http.ResponseWriter, httpStatus int, response *Response, err error) {
+	if err != nil {
+		response.Error = err.Error()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(httpStatus)
+	json.NewEncoder(w).Encode(response)
+}
+
+// UserTagsEndpoint function that returns the tags a user labeled their exercises with, the most used first
+func UserTagsEndpoint(w http.ResponseWriter, r *http.Request) {
+	newResponse := &Response{}
+	params := mux.Vars(r)
+
+	userID, err := strconv.ParseInt(params["userId"], 10, 64)
+	if err != nil {
+		response(w, http.StatusBadRequest, newResponse, ErrInvalidUserID)
+		return
+	}
+
+	if !auth.FromRequest(r).CanActFor(userID) {
+		response(w, http.StatusForbidden, newResponse, auth.ErrForbidden)
+		return
+	}
+
+	database, err := openDatabase()
+	if err != nil {
+		response(w, http.StatusInternalServerError, newResponse, err)
+		return
+	}
+
+	usages, err := getUsages(database, userID)
+	if err != nil {
+		response(w, http.StatusInternalServerError, newResponse, err)
+		return
+	}
+
+	newResponse.Tags = usages
+	response(w, http.StatusOK, newResponse, err)
+}
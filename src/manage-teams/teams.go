@@ -0,0 +1,347 @@
+package teams
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	auth "../authenticate-request"
+	text "../exercise-text"
+	"github.com/gorilla/mux"
+)
+
+var (
+	// ErrInvalidID Error when team id is not valid
+	ErrInvalidID = errors.New("Invalid team id")
+	// ErrMissingName Error when name field is not received
+	ErrMissingName = errors.New("Missing name")
+	// ErrInvalidName Error when name field has characters other than letters, numbers and spaces
+	ErrInvalidName = errors.New("Invalid name must only have letters, numbers and spaces")
+	// ErrMissingUserID Error when userId field is not received
+	ErrMissingUserID = errors.New("Missing userId")
+	// ErrNoTeamFound The team you requested does not exists
+	ErrNoTeamFound = errors.New("The team you requested does not exists")
+	// ErrNoUserFound The user you intended to add does not exists
+	ErrNoUserFound = errors.New("The user you intended to add does not exists")
+	// ErrNoMemberFound The user is not a member of the team
+	ErrNoMemberFound = errors.New("The user is not a member of the team")
+	// ErrNotTeamOwner Error when the members of a team are changed by someone other than its owner
+	ErrNotTeamOwner = errors.New("Only the owner of the team can change its members")
+)
+
+// Team group of users ranked together
+type Team struct {
+	// ID field of Team
+	ID int64 `json:"id"`
+	// Name of the Team
+	Name string `json:"name"`
+	// OwnerID id of the user who created the team, 0 when only admins manage it
+	OwnerID int64 `json:"ownerId"`
+	// Members ids of the users in the team
+	Members []int64 `json:"members"`
+}
+
+// Member Request structure to add a user to a team
+type Member struct {
+	// UserID id field of User
+	UserID int64 `json:"userId"`
+}
+
+// Response for /teams
+type Response struct {
+	Team  *Team  `json:"team,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+func openDatabase() (*sql.DB, error) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+
+	return sql.Open("sqlite3", fmt.Sprintf("%s/egym.db", dir))
+}
+
+func (t *Team) validateCreateTeamRequest() error {
+	name, ok := text.Name(t.Name)
+	if name == "" {
+		return ErrMissingName
+	}
+
+	if !ok {
+		return ErrInvalidName
+	}
+	t.Name = name
+
+	return nil
+}
+
+func (t *Team) createTeam() error {
+	database, err := openDatabase()
+	if err != nil {
+		return err
+	}
+
+	result, err := database.Exec(`INSERT INTO teams (NAME, OWNER_ID, CREATED_AT) VALUES ($1, $2, $3)`, t.Name, t.OwnerID, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+
+	t.ID, err = result.LastInsertId()
+	t.Members = []int64{}
+
+	return err
+}
+
+func getTeam(ID int64) (*Team, error) {
+	database, err := openDatabase()
+	if err != nil {
+		return nil, err
+	}
+
+	team := &Team{ID: ID, Members: []int64{}}
+	err = database.QueryRow(`SELECT NAME, OWNER_ID FROM teams WHERE ID=$1`, ID).Scan(&team.Name, &team.OwnerID)
+	if err == sql.ErrNoRows {
+		return nil, ErrNoTeamFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := database.Query(`SELECT USER_ID FROM team_members WHERE TEAM_ID=$1 ORDER BY USER_ID`, ID)
+	if err != nil {
+		return nil, err
+	}
+	defer result.Close()
+
+	for result.Next() {
+		var userID int64
+		if err := result.Scan(&userID); err != nil {
+			return nil, err
+		}
+
+		team.Members = append(team.Members, userID)
+	}
+
+	return team, result.Err()
+}
+
+func addMember(teamID int64, userID int64) error {
+	database, err := openDatabase()
+	if err != nil {
+		return err
+	}
+
+	var totalUsers int
+	err = database.QueryRow(`SELECT COUNT(*) FROM users WHERE ID=$1`, userID).Scan(&totalUsers)
+	if err != nil {
+		return err
+	}
+
+	if totalUsers == 0 {
+		return ErrNoUserFound
+	}
+
+	_, err = database.Exec(`INSERT OR IGNORE INTO team_members (TEAM_ID, USER_ID) VALUES ($1, $2)`, teamID, userID)
+
+	return err
+}
+
+func removeMember(teamID int64, userID int64) error {
+	database, err := openDatabase()
+	if err != nil {
+		return err
+	}
+
+	result, err := database.Exec(`DELETE FROM team_members WHERE TEAM_ID=$1 AND USER_ID=$2`, teamID, userID)
+	if err != nil {
+		return err
+	}
+
+	removed, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if removed == 0 {
+		return ErrNoMemberFound
+	}
+
+	return nil
+}
+
+func response(w http.ResponseWriter, httpStatus int, response *Response, err error) {
+	if err != nil {
+		response.Error = err.Error()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(httpStatus)
+	json.NewEncoder(w).Encode(response)
+}
+
+func notFoundOrInternal(err error) int {
+	if err == ErrNoTeamFound || err == ErrNoMemberFound {
+		return http.StatusNotFound
+	}
+
+	return http.StatusInternalServerError
+}
+
+// TeamEndpoint function that handles the creation of a team
+func TeamEndpoint(w http.ResponseWriter, r *http.Request) {
+	newResponse := &Response{}
+	team := &Team{}
+
+	defer r.Body.Close()
+
+	if err := json.NewDecoder(r.Body).Decode(team); err != nil {
+		response(w, http.StatusBadRequest, newResponse, err)
+		return
+	}
+
+	err := team.validateCreateTeamRequest()
+	if err != nil {
+		response(w, http.StatusBadRequest, newResponse, err)
+		return
+	}
+
+	// the members of the team are managed by the user creating it
+	team.OwnerID = auth.FromRequest(r).UserID
+
+	err = team.createTeam()
+	if err != nil {
+		response(w, http.StatusInternalServerError, newResponse, err)
+		return
+	}
+
+	newResponse.Team = team
+	response(w, http.StatusCreated, newResponse, err)
+}
+
+// GetTeamEndpoint function that returns a team and its members
+func GetTeamEndpoint(w http.ResponseWriter, r *http.Request) {
+	newResponse := &Response{}
+	params := mux.Vars(r)
+
+	teamID, err := strconv.ParseInt(params["teamId"], 10, 64)
+	if err != nil {
+		response(w, http.StatusBadRequest, newResponse, ErrInvalidID)
+		return
+	}
+
+	team, err := getTeam(teamID)
+	if err != nil {
+		response(w, notFoundOrInternal(err), newResponse, err)
+		return
+	}
+
+	newResponse.Team = team
+	response(w, http.StatusOK, newResponse, err)
+}
+
+// AddMemberEndpoint function that adds a user to a team
+func AddMemberEndpoint(w http.ResponseWriter, r *http.Request) {
+	newResponse := &Response{}
+	member := &Member{}
+	params := mux.Vars(r)
+
+	defer r.Body.Close()
+
+	if err := json.NewDecoder(r.Body).Decode(member); err != nil {
+		response(w, http.StatusBadRequest, newResponse, err)
+		return
+	}
+
+	teamID, err := strconv.ParseInt(params["teamId"], 10, 64)
+	if err != nil {
+		response(w, http.StatusBadRequest, newResponse, ErrInvalidID)
+		return
+	}
+
+	if member.UserID == 0 {
+		response(w, http.StatusBadRequest, newResponse, ErrMissingUserID)
+		return
+	}
+
+	team, err := getTeam(teamID)
+	if err != nil {
+		response(w, notFoundOrInternal(err), newResponse, err)
+		return
+	}
+
+	if !auth.FromRequest(r).CanActFor(team.OwnerID) {
+		response(w, http.StatusForbidden, newResponse, ErrNotTeamOwner)
+		return
+	}
+
+	err = addMember(teamID, member.UserID)
+	if err == ErrNoUserFound {
+		response(w, http.StatusBadRequest, newResponse, err)
+		return
+	}
+	if err != nil {
+		response(w, http.StatusInternalServerError, newResponse, err)
+		return
+	}
+
+	team, err = getTeam(teamID)
+	if err != nil {
+		response(w, notFoundOrInternal(err), newResponse, err)
+		return
+	}
+
+	newResponse.Team = team
+	response(w, http.StatusOK, newResponse, err)
+}
+
+// RemoveMemberEndpoint function that removes a user from a team
+func RemoveMemberEndpoint(w http.ResponseWriter, r *http.Request) {
+	newResponse := &Response{}
+	params := mux.Vars(r)
+
+	teamID, err := strconv.ParseInt(params["teamId"], 10, 64)
+	if err != nil {
+		response(w, http.StatusBadRequest, newResponse, ErrInvalidID)
+		return
+	}
+
+	userID, err := strconv.ParseInt(params["userId"], 10, 64)
+	if err != nil {
+		response(w, http.StatusBadRequest, newResponse, ErrMissingUserID)
+		return
+	}
+
+	team, err := getTeam(teamID)
+	if err != nil {
+		response(w, notFoundOrInternal(err), newResponse, err)
+		return
+	}
+
+	// members may leave a team on their own
+	principal := auth.FromRequest(r)
+	if !principal.CanActFor(team.OwnerID) && !principal.CanActFor(userID) {
+		response(w, http.StatusForbidden, newResponse, ErrNotTeamOwner)
+		return
+	}
+
+	err = removeMember(teamID, userID)
+	if err != nil {
+		response(w, notFoundOrInternal(err), newResponse, err)
+		return
+	}
+
+	team, err = getTeam(teamID)
+	if err != nil {
+		response(w, notFoundOrInternal(err), newResponse, err)
+		return
+	}
+
+	newResponse.Team = team
+	response(w, http.StatusOK, newResponse, err)
+}
@@ -0,0 +1,299 @@
+package users
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	auth "../authenticate-request"
+	"github.com/gorilla/mux"
+)
+
+var (
+	// ErrInvalidCalendarToken Error when the calendar token does not belong to the user
+	ErrInvalidCalendarToken = errors.New("Invalid calendar token")
+	// ErrNoCalendarFound Error when the user has not created the calendar yet
+	ErrNoCalendarFound = errors.New("The user has no calendar, POST to create it")
+)
+
+const (
+	calendarTokenBytes = 32
+	calendarTimeFormat = "20060102T150405Z"
+	calendarLineLength = 75
+)
+
+// Calendar private subscription to the exercises of a User
+type Calendar struct {
+	// Token secret that grants read access to the feed
+	Token string `json:"token"`
+	// URL path of the feed, including the token
+	URL string `json:"url"`
+}
+
+// calendarEvent exercise as shown in the feed
+type calendarEvent struct {
+	ID           int64
+	Description  string
+	ExerciseType string
+	StartTime    time.Time
+	FinishTime   time.Time
+	Version      int64
+}
+
+func newCalendarToken() (string, error) {
+	token := make([]byte, calendarTokenBytes)
+	if _, err := rand.Read(token); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(token), nil
+}
+
+func newCalendar(userID int64, token string) *Calendar {
+	return &Calendar{
+		Token: token,
+		URL:   fmt.Sprintf("/users/%d/exercises.ics?token=%s", userID, token),
+	}
+}
+
+// getCalendar returns the calendar of the user, without creating it
+func getCalendar(userID int64) (*Calendar, error) {
+	database, err := openDatabase()
+	if err != nil {
+		return nil, err
+	}
+
+	var token sql.NullString
+	err = database.QueryRow(`SELECT CALENDAR_TOKEN FROM users WHERE ID=$1`, userID).Scan(&token)
+	if err == sql.ErrNoRows {
+		return nil, ErrNoUserFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if !token.Valid || token.String == "" {
+		return nil, ErrNoCalendarFound
+	}
+
+	return newCalendar(userID, token.String), nil
+}
+
+// createCalendar issues a new token for the calendar of the user, revoking the subscriptions made with the previous one
+func createCalendar(userID int64) (*Calendar, error) {
+	database, err := openDatabase()
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := newCalendarToken()
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := database.Exec(`UPDATE users SET CALENDAR_TOKEN=$1 WHERE ID=$2`, token, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if updated == 0 {
+		return nil, ErrNoUserFound
+	}
+
+	return newCalendar(userID, token), nil
+}
+
+func checkCalendarToken(database *sql.DB, userID int64, token string) (string, error) {
+	var displayName string
+	var stored sql.NullString
+
+	err := database.QueryRow(`SELECT DISPLAY_NAME, CALENDAR_TOKEN FROM users WHERE ID=$1`, userID).Scan(&displayName, &stored)
+	if err == sql.ErrNoRows {
+		return "", ErrInvalidCalendarToken
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if !stored.Valid || token == "" || subtle.ConstantTimeCompare([]byte(stored.String), []byte(token)) != 1 {
+		return "", ErrInvalidCalendarToken
+	}
+
+	return displayName, nil
+}
+
+func getCalendarEvents(database *sql.DB, userID int64) ([]*calendarEvent, error) {
+	result, err := database.Query(`SELECT ID, DESCRIPTION, TYPE, START_TIME, FINISH_TIME, VERSION FROM exercises WHERE USER_ID=$1 ORDER BY START_TIME`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer result.Close()
+
+	events := []*calendarEvent{}
+	for result.Next() {
+		event := &calendarEvent{}
+		if err := result.Scan(&event.ID, &event.Description, &event.ExerciseType, &event.StartTime, &event.FinishTime, &event.Version); err != nil {
+			return nil, err
+		}
+
+		events = append(events, event)
+	}
+
+	return events, result.Err()
+}
+
+// escapeText escapes a TEXT value as defined by RFC 5545 section 3.3.11
+func escapeText(text string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`).Replace(text)
+}
+
+// foldLine splits a content line in lines of at most 75 octets without breaking UTF-8 characters
+func foldLine(line string) string {
+	var folded strings.Builder
+	length := 0
+
+	for _, character := range line {
+		size := len(string(character))
+		if length+size > calendarLineLength {
+			folded.WriteString("\r\n ")
+			length = 1
+		}
+
+		folded.WriteRune(character)
+		length += size
+	}
+
+	folded.WriteString("\r\n")
+
+	return folded.String()
+}
+
+// typeTitle RUNNING is shown as Running
+func typeTitle(exerciseType string) string {
+	title := strings.ToLower(strings.Replace(exerciseType, "_", " ", -1))
+	if title == "" {
+		return title
+	}
+
+	return strings.ToUpper(title[:1]) + title[1:]
+}
+
+func writeCalendar(w http.ResponseWriter, displayName string, events []*calendarEvent) {
+	now := time.Now().UTC().Format(calendarTimeFormat)
+	lines := []string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"PRODID:-//exerciseAPI//Exercises//EN",
+		"CALSCALE:GREGORIAN",
+		"METHOD:PUBLISH",
+		"X-WR-CALNAME:" + escapeText(displayName+" exercises"),
+	}
+
+	for _, event := range events {
+		lines = append(lines,
+			"BEGIN:VEVENT",
+			fmt.Sprintf("UID:exercise-%d@exerciseapi", event.ID),
+			"DTSTAMP:"+now,
+			"DTSTART:"+event.StartTime.UTC().Format(calendarTimeFormat),
+			"DTEND:"+event.FinishTime.UTC().Format(calendarTimeFormat),
+			"SEQUENCE:"+strconv.FormatInt(event.Version-1, 10),
+			"SUMMARY:"+escapeText(typeTitle(event.ExerciseType)),
+			"DESCRIPTION:"+escapeText(event.Description),
+			"CATEGORIES:"+escapeText(event.ExerciseType),
+			"END:VEVENT",
+		)
+	}
+
+	lines = append(lines, "END:VCALENDAR")
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Content-Disposition", `inline; filename="exercises.ics"`)
+	w.WriteHeader(http.StatusOK)
+
+	for _, line := range lines {
+		w.Write([]byte(foldLine(line)))
+	}
+}
+
+// CalendarEndpoint function that returns the private calendar subscription of a user
+func CalendarEndpoint(w http.ResponseWriter, r *http.Request) {
+	newResponse := &Response{}
+	params := mux.Vars(r)
+
+	userID, err := strconv.ParseInt(params["userId"], 10, 64)
+	if err != nil {
+		response(w, http.StatusBadRequest, newResponse, ErrInvalidID)
+		return
+	}
+
+	if !auth.FromRequest(r).CanActFor(userID) {
+		response(w, http.StatusForbidden, newResponse, auth.ErrForbidden)
+		return
+	}
+
+	// only POST issues a token, a GET does not create the calendar
+	var calendar *Calendar
+	if r.Method == http.MethodPost {
+		calendar, err = createCalendar(userID)
+	} else {
+		calendar, err = getCalendar(userID)
+	}
+	if err == ErrNoCalendarFound {
+		response(w, http.StatusNotFound, newResponse, err)
+		return
+	}
+	if err != nil {
+		response(w, notFoundOrInternal(err), newResponse, err)
+		return
+	}
+
+	newResponse.Calendar = calendar
+	response(w, http.StatusOK, newResponse, err)
+}
+
+// CalendarFeedEndpoint function that returns the exercises of a user as an iCalendar feed, authenticated by the token of the URL
+func CalendarFeedEndpoint(w http.ResponseWriter, r *http.Request) {
+	newResponse := &Response{}
+	params := mux.Vars(r)
+
+	userID, err := strconv.ParseInt(params["userId"], 10, 64)
+	if err != nil {
+		response(w, http.StatusBadRequest, newResponse, ErrInvalidID)
+		return
+	}
+
+	database, err := openDatabase()
+	if err != nil {
+		response(w, http.StatusInternalServerError, newResponse, err)
+		return
+	}
+
+	displayName, err := checkCalendarToken(database, userID, r.URL.Query().Get("token"))
+	if err == ErrInvalidCalendarToken {
+		response(w, http.StatusForbidden, newResponse, err)
+		return
+	}
+	if err != nil {
+		response(w, http.StatusInternalServerError, newResponse, err)
+		return
+	}
+
+	events, err := getCalendarEvents(database, userID)
+	if err != nil {
+		response(w, http.StatusInternalServerError, newResponse, err)
+		return
+	}
+
+	writeCalendar(w, displayName, events)
+}
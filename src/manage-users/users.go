@@ -0,0 +1,344 @@
+package users
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	auth "../authenticate-request"
+	text "../exercise-text"
+	"github.com/gorilla/mux"
+)
+
+var (
+	// ErrInvalidID Error when user id is not valid
+	ErrInvalidID = errors.New("Invalid user id")
+	// ErrMissingDisplayName Error when displayName field is not received
+	ErrMissingDisplayName = errors.New("Missing displayName")
+	// ErrInvalidDisplayName Error when displayName field has characters other than letters, numbers, spaces and punctuation
+	ErrInvalidDisplayName = errors.New("Invalid displayName must only have letters, numbers, spaces and punctuation")
+	// ErrDisplayNameTooLong Error when displayName field is longer than allowed
+	ErrDisplayNameTooLong = errors.New("Invalid displayName must not be longer than 100 characters")
+	// ErrInvalidTimezone Error when timezone field is not an IANA time zone
+	ErrInvalidTimezone = errors.New("Invalid timezone must be an IANA time zone")
+	// ErrInvalidWeight Error when weight field is negative
+	ErrInvalidWeight = errors.New("Invalid weight must be a positive number of kilograms")
+	// ErrInvalidBirthYear Error when birthYear field is out of range
+	ErrInvalidBirthYear = errors.New("Invalid birthYear")
+	// ErrInvalidMaxHeartRate Error when maxHeartRate field is out of range
+	ErrInvalidMaxHeartRate = errors.New("Invalid maxHeartRate must be between 100 and 250 beats per minute")
+	// ErrNoUserFound The user you requested does not exists
+	ErrNoUserFound = errors.New("The user you requested does not exists")
+)
+
+const (
+	defaultTimezone = "UTC"
+	minBirthYear    = 1900
+	minHeartRate    = 100
+	maxHeartRate    = 250
+)
+
+// User structure and Request structure
+type User struct {
+	// ID field of User
+	ID int64 `json:"id"`
+	// DisplayName name shown in rankings
+	DisplayName string `json:"displayName"`
+	// Timezone IANA time zone of the User
+	Timezone string `json:"timezone"`
+	// Weight of the User in kilograms
+	Weight float64 `json:"weight,omitempty"`
+	// BirthYear year the User was born
+	BirthYear int `json:"birthYear,omitempty"`
+	// MaxHeartRate beats per minute the heart rate zones are based on, estimated from the age when not set
+	MaxHeartRate int64 `json:"maxHeartRate,omitempty"`
+	// Active false once the User is deactivated
+	Active bool `json:"active"`
+}
+
+// Response for /users
+type Response struct {
+	User     *User     `json:"user,omitempty"`
+	Calendar *Calendar `json:"calendar,omitempty"`
+	Error    string    `json:"error,omitempty"`
+}
+
+func openDatabase() (*sql.DB, error) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+
+	return sql.Open("sqlite3", fmt.Sprintf("%s/egym.db", dir))
+}
+
+func (u *User) validateUserRequest() error {
+	if u.DisplayName == "" {
+		return ErrMissingDisplayName
+	}
+
+	// display names follow the rules of descriptions, in any language
+	displayName, err := text.Description(u.DisplayName)
+	if err == text.ErrDescriptionTooLong {
+		return ErrDisplayNameTooLong
+	}
+	if err != nil {
+		return ErrInvalidDisplayName
+	}
+	u.DisplayName = displayName
+
+	if u.Timezone == "" {
+		u.Timezone = defaultTimezone
+	}
+
+	if _, err := time.LoadLocation(u.Timezone); err != nil {
+		return ErrInvalidTimezone
+	}
+
+	if u.Weight < 0 {
+		return ErrInvalidWeight
+	}
+
+	if u.BirthYear != 0 && (u.BirthYear < minBirthYear || u.BirthYear > time.Now().Year()) {
+		return ErrInvalidBirthYear
+	}
+
+	if u.MaxHeartRate != 0 && (u.MaxHeartRate < minHeartRate || u.MaxHeartRate > maxHeartRate) {
+		return ErrInvalidMaxHeartRate
+	}
+
+	return nil
+}
+
+func (u *User) createUser() error {
+	database, err := openDatabase()
+	if err != nil {
+		return err
+	}
+
+	result, err := database.Exec(`INSERT INTO users (DISPLAY_NAME, TIMEZONE, WEIGHT, BIRTH_YEAR, MAX_HEART_RATE, ACTIVE, CREATED_AT) VALUES ($1, $2, $3, $4, $5, 1, $6)`, u.DisplayName, u.Timezone, u.Weight, u.BirthYear, u.MaxHeartRate, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+
+	u.ID, err = result.LastInsertId()
+	u.Active = true
+
+	return err
+}
+
+func getUser(ID int64) (*User, error) {
+	database, err := openDatabase()
+	if err != nil {
+		return nil, err
+	}
+
+	user := &User{ID: ID}
+	err = database.QueryRow(`SELECT DISPLAY_NAME, TIMEZONE, WEIGHT, BIRTH_YEAR, MAX_HEART_RATE, ACTIVE FROM users WHERE ID=$1`, ID).Scan(&user.DisplayName, &user.Timezone, &user.Weight, &user.BirthYear, &user.MaxHeartRate, &user.Active)
+	if err == sql.ErrNoRows {
+		return nil, ErrNoUserFound
+	}
+
+	return user, err
+}
+
+func (u *User) updateUser(ID int64) error {
+	database, err := openDatabase()
+	if err != nil {
+		return err
+	}
+
+	result, err := database.Exec(`UPDATE users SET DISPLAY_NAME=$1, TIMEZONE=$2, WEIGHT=$3, BIRTH_YEAR=$4, MAX_HEART_RATE=$5 WHERE ID=$6`, u.DisplayName, u.Timezone, u.Weight, u.BirthYear, u.MaxHeartRate, ID)
+	if err != nil {
+		return err
+	}
+
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if updated == 0 {
+		return ErrNoUserFound
+	}
+
+	return nil
+}
+
+func deactivateUser(ID int64) error {
+	database, err := openDatabase()
+	if err != nil {
+		return err
+	}
+
+	result, err := database.Exec(`UPDATE users SET ACTIVE=0 WHERE ID=$1`, ID)
+	if err != nil {
+		return err
+	}
+
+	deactivated, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if deactivated == 0 {
+		return ErrNoUserFound
+	}
+
+	return nil
+}
+
+func response(w http.ResponseWriter, httpStatus int, response *Response, err error) {
+	if err != nil {
+		response.Error = err.Error()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(httpStatus)
+	json.NewEncoder(w).Encode(response)
+}
+
+func notFoundOrInternal(err error) int {
+	if err == ErrNoUserFound {
+		return http.StatusNotFound
+	}
+
+	return http.StatusInternalServerError
+}
+
+// UserEndpoint function that handles the creation of a user
+func UserEndpoint(w http.ResponseWriter, r *http.Request) {
+	newResponse := &Response{}
+	user := &User{}
+
+	defer r.Body.Close()
+
+	if err := json.NewDecoder(r.Body).Decode(user); err != nil {
+		response(w, http.StatusBadRequest, newResponse, err)
+		return
+	}
+
+	err := user.validateUserRequest()
+	if err != nil {
+		response(w, http.StatusBadRequest, newResponse, err)
+		return
+	}
+
+	err = user.createUser()
+	if err != nil {
+		response(w, http.StatusInternalServerError, newResponse, err)
+		return
+	}
+
+	newResponse.User = user
+	response(w, http.StatusCreated, newResponse, err)
+}
+
+// GetUserEndpoint function that returns the profile of a user
+func GetUserEndpoint(w http.ResponseWriter, r *http.Request) {
+	newResponse := &Response{}
+	params := mux.Vars(r)
+
+	userID, err := strconv.ParseInt(params["userId"], 10, 64)
+	if err != nil {
+		response(w, http.StatusBadRequest, newResponse, ErrInvalidID)
+		return
+	}
+
+	if !auth.FromRequest(r).CanActFor(userID) {
+		response(w, http.StatusForbidden, newResponse, auth.ErrForbidden)
+		return
+	}
+
+	user, err := getUser(userID)
+	if err != nil {
+		response(w, notFoundOrInternal(err), newResponse, err)
+		return
+	}
+
+	newResponse.User = user
+	response(w, http.StatusOK, newResponse, err)
+}
+
+// UpdateUserEndpoint function that updates the profile of a user
+func UpdateUserEndpoint(w http.ResponseWriter, r *http.Request) {
+	newResponse := &Response{}
+	user := &User{}
+	params := mux.Vars(r)
+
+	defer r.Body.Close()
+
+	if err := json.NewDecoder(r.Body).Decode(user); err != nil {
+		response(w, http.StatusBadRequest, newResponse, err)
+		return
+	}
+
+	userID, err := strconv.ParseInt(params["userId"], 10, 64)
+	if err != nil {
+		response(w, http.StatusBadRequest, newResponse, ErrInvalidID)
+		return
+	}
+
+	if !auth.FromRequest(r).CanActFor(userID) {
+		response(w, http.StatusForbidden, newResponse, auth.ErrForbidden)
+		return
+	}
+
+	err = user.validateUserRequest()
+	if err != nil {
+		response(w, http.StatusBadRequest, newResponse, err)
+		return
+	}
+
+	err = user.updateUser(userID)
+	if err != nil {
+		response(w, notFoundOrInternal(err), newResponse, err)
+		return
+	}
+
+	user, err = getUser(userID)
+	if err != nil {
+		response(w, notFoundOrInternal(err), newResponse, err)
+		return
+	}
+
+	newResponse.User = user
+	response(w, http.StatusOK, newResponse, err)
+}
+
+// DeactivateUserEndpoint function that deactivates a user, keeping its exercises
+func DeactivateUserEndpoint(w http.ResponseWriter, r *http.Request) {
+	newResponse := &Response{}
+	params := mux.Vars(r)
+
+	userID, err := strconv.ParseInt(params["userId"], 10, 64)
+	if err != nil {
+		response(w, http.StatusBadRequest, newResponse, ErrInvalidID)
+		return
+	}
+
+	if !auth.FromRequest(r).CanActFor(userID) {
+		response(w, http.StatusForbidden, newResponse, auth.ErrForbidden)
+		return
+	}
+
+	err = deactivateUser(userID)
+	if err != nil {
+		response(w, notFoundOrInternal(err), newResponse, err)
+		return
+	}
+
+	user, err := getUser(userID)
+	if err != nil {
+		response(w, notFoundOrInternal(err), newResponse, err)
+		return
+	}
+
+	newResponse.User = user
+	response(w, http.StatusOK, newResponse, err)
+}
@@ -0,0 +1,456 @@
+package moderation
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	auth "../authenticate-request"
+	archive "../exercise-archive"
+	history "../exercise-history"
+	plausibility "../exercise-plausibility"
+	records "../exercise-records"
+	version "../exercise-version"
+	notify "../notify-users"
+	"github.com/gorilla/mux"
+)
+
+// Decision outcome of the review of an exercise
+type Decision string
+
+var (
+	// ErrInvalidID Error when exercise id is not valid
+	ErrInvalidID = errors.New("Invalid exercise id")
+	// ErrNoExerciseFound The exercise does not exists
+	ErrNoExerciseFound = errors.New("The exercise you requested does not exists")
+	// ErrMissingReason Error when reason field is not received
+	ErrMissingReason = errors.New("Missing reason")
+	// ErrInvalidReason Error when reason field is too long
+	ErrInvalidReason = errors.New("Invalid reason must have at most 500 characters")
+	// ErrOwnExercise Error when a user reports an exercise of their own
+	ErrOwnExercise = errors.New("You can not report your own exercise")
+	// ErrAlreadyReported Error when the principal has a pending report of the exercise
+	ErrAlreadyReported = errors.New("You already reported this exercise")
+	// ErrNotPending Error when reviewing an exercise that is neither flagged nor reported
+	ErrNotPending = errors.New("The exercise is neither flagged nor reported")
+)
+
+const (
+	// ApproveDecision the exercise is ranked
+	ApproveDecision Decision = "APPROVE"
+	// RejectDecision the exercise is not ranked
+	RejectDecision Decision = "REJECT"
+
+	maxReasonLength = 500
+)
+
+// Report of an exercise by another user
+type Report struct {
+	ID         int64     `json:"id"`
+	ExerciseID int64     `json:"exerciseId"`
+	Reporter   string    `json:"reporter"`
+	Reason     string    `json:"reason"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+// Review decision taken on a flagged or reported exercise
+type Review struct {
+	ExerciseID int64     `json:"exerciseId"`
+	Decision   Decision  `json:"decision"`
+	Reason     string    `json:"reason,omitempty"`
+	Reviewer   string    `json:"reviewer"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+// Item exercise waiting for review along its pending reports
+type Item struct {
+	ExerciseID   int64               `json:"exerciseId"`
+	UserID       int64               `json:"userId"`
+	Description  string              `json:"description"`
+	ExerciseType string              `json:"type"`
+	StartTime    time.Time           `json:"startTime"`
+	Duration     int64               `json:"duration"`
+	Calories     int64               `json:"calories"`
+	Distance     float64             `json:"distance,omitempty"`
+	Status       plausibility.Status `json:"status"`
+	FlagReason   string              `json:"flagReason,omitempty"`
+	Reports      []*Report           `json:"reports,omitempty"`
+}
+
+// Request structure of reports and reviews
+type Request struct {
+	Reason string `json:"reason"`
+}
+
+// Response for /exercise/{exerciseId}/reports and /admin/moderation
+type Response struct {
+	Report *Report `json:"report,omitempty"`
+	Queue  []*Item `json:"queue,omitempty"`
+	Review *Review `json:"review,omitempty"`
+	Error  string  `json:"error,omitempty"`
+}
+
+func openDatabase() (*sql.DB, error) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+
+	return sql.Open("sqlite3", fmt.Sprintf("%s/egym.db", dir))
+}
+
+func validateReason(reason string, required bool) error {
+	if required && strings.TrimSpace(reason) == "" {
+		return ErrMissingReason
+	}
+
+	if utf8.RuneCountInString(reason) > maxReasonLength {
+		return ErrInvalidReason
+	}
+
+	return nil
+}
+
+func getOwner(database *sql.DB, exerciseID int64) (int64, error) {
+	var userID int64
+	err := database.QueryRow(`SELECT USER_ID FROM exercises WHERE ID=$1`, exerciseID).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return 0, ErrNoExerciseFound
+	}
+
+	return userID, err
+}
+
+func report(database *sql.DB, exerciseID int64, reporter string, reason string) (*Report, error) {
+	var pending int
+	err := database.QueryRow(`SELECT COUNT(*) FROM exercise_reports WHERE EXERCISE_ID=$1 AND REPORTER=$2 AND RESOLVED=0`, exerciseID, reporter).Scan(&pending)
+	if err != nil {
+		return nil, err
+	}
+
+	if pending > 0 {
+		return nil, ErrAlreadyReported
+	}
+
+	newReport := &Report{ExerciseID: exerciseID, Reporter: reporter, Reason: reason, CreatedAt: time.Now().UTC()}
+	result, err := database.Exec(`INSERT INTO exercise_reports (EXERCISE_ID, REPORTER, REASON, RESOLVED, CREATED_AT) VALUES ($1, $2, $3, 0, $4)`, exerciseID, reporter, reason, newReport.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	newReport.ID, err = result.LastInsertId()
+
+	return newReport, err
+}
+
+func getPendingReports(database *sql.DB) (map[int64][]*Report, error) {
+	result, err := database.Query(`SELECT ID, EXERCISE_ID, REPORTER, REASON, CREATED_AT FROM exercise_reports WHERE RESOLVED=0 ORDER BY ID`)
+	if err != nil {
+		return nil, err
+	}
+	defer result.Close()
+
+	reports := map[int64][]*Report{}
+	for result.Next() {
+		pending := &Report{}
+		if err := result.Scan(&pending.ID, &pending.ExerciseID, &pending.Reporter, &pending.Reason, &pending.CreatedAt); err != nil {
+			return nil, err
+		}
+
+		reports[pending.ExerciseID] = append(reports[pending.ExerciseID], pending)
+	}
+
+	return reports, result.Err()
+}
+
+// getQueue flagged exercises and exercises with pending reports, the longest waiting first
+func getQueue(database *sql.DB) ([]*Item, error) {
+	reports, err := getPendingReports(database)
+	if err != nil {
+		return nil, err
+	}
+
+	sqlStatement := `SELECT ID, USER_ID, DESCRIPTION, TYPE, START_TIME, DURATION, CALORIES, DISTANCE, STATUS, FLAG_REASON FROM exercises WHERE STATUS=$1 OR ID IN (SELECT EXERCISE_ID FROM exercise_reports WHERE RESOLVED=0) ORDER BY ID`
+	result, err := database.Query(sqlStatement, plausibility.FlaggedStatus)
+	if err != nil {
+		return nil, err
+	}
+	defer result.Close()
+
+	queue := []*Item{}
+	for result.Next() {
+		item := &Item{}
+		if err := result.Scan(&item.ExerciseID, &item.UserID, &item.Description, &item.ExerciseType, &item.StartTime, &item.Duration, &item.Calories, &item.Distance, &item.Status, &item.FlagReason); err != nil {
+			return nil, err
+		}
+
+		item.Reports = reports[item.ExerciseID]
+		queue = append(queue, item)
+	}
+
+	return queue, result.Err()
+}
+
+func isPending(tx *sql.Tx, exerciseID int64) (int64, bool, error) {
+	var userID int64
+	var status plausibility.Status
+	err := tx.QueryRow(`SELECT USER_ID, STATUS FROM exercises WHERE ID=$1`, exerciseID).Scan(&userID, &status)
+	if err == sql.ErrNoRows {
+		return 0, false, ErrNoExerciseFound
+	}
+	if err != nil {
+		return 0, false, err
+	}
+
+	var reports int
+	err = tx.QueryRow(`SELECT COUNT(*) FROM exercise_reports WHERE EXERCISE_ID=$1 AND RESOLVED=0`, exerciseID).Scan(&reports)
+
+	return userID, status == plausibility.FlaggedStatus || reports > 0, err
+}
+
+func notification(decision Decision, exerciseID int64, reason string) (notify.Kind, string) {
+	kind := notify.ExerciseApprovedKind
+	message := fmt.Sprintf("Your exercise %d was reviewed and is ranked", exerciseID)
+	if decision == RejectDecision {
+		kind = notify.ExerciseRejectedKind
+		message = fmt.Sprintf("Your exercise %d was reviewed and will not be ranked", exerciseID)
+	}
+
+	if reason != "" {
+		message = fmt.Sprintf("%s: %s", message, reason)
+	}
+
+	return kind, message
+}
+
+// review applies the decision, resolves the pending reports, records the change of status and notifies the owner, returning the new version
+func review(database *sql.DB, newReview *Review) (int64, error) {
+	tx, err := database.Begin()
+	if err != nil {
+		return 0, err
+	}
+
+	userID, pending, err := isPending(tx, newReview.ExerciseID)
+	if err == nil && !pending {
+		err = ErrNotPending
+	}
+	if err == nil {
+		err = archive.Check(tx, newReview.ExerciseID)
+	}
+
+	var before *history.State
+	if err == nil {
+		before, err = history.Load(tx, newReview.ExerciseID)
+	}
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	status := plausibility.ApprovedStatus
+	if newReview.Decision == RejectDecision {
+		status = plausibility.RejectedStatus
+	}
+
+	kind, message := notification(newReview.Decision, newReview.ExerciseID, newReview.Reason)
+	statements := []struct {
+		query string
+		args  []interface{}
+	}{
+		{`UPDATE exercises SET STATUS=$1, VERSION=VERSION+1 WHERE ID=$2`, []interface{}{status, newReview.ExerciseID}},
+		{`UPDATE exercise_reports SET RESOLVED=1 WHERE EXERCISE_ID=$1`, []interface{}{newReview.ExerciseID}},
+		{`INSERT INTO exercise_reviews (EXERCISE_ID, DECISION, REASON, REVIEWER, CREATED_AT) VALUES ($1, $2, $3, $4, $5)`, []interface{}{newReview.ExerciseID, newReview.Decision, newReview.Reason, newReview.Reviewer, newReview.CreatedAt}},
+	}
+
+	for _, statement := range statements {
+		if _, err = tx.Exec(statement.query, statement.args...); err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+	}
+
+	if err = notify.Send(tx, userID, kind, newReview.ExerciseID, message); err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	after, err := history.Load(tx, newReview.ExerciseID)
+	if err == nil {
+		err = history.Record(tx, newReview.ExerciseID, history.UpdateAction, newReview.Reviewer, before, after)
+	}
+
+	// approved exercises may beat records, rejected ones give back the ones they held
+	if err == nil {
+		_, err = records.Detect(tx, userID, after.ExerciseType, newReview.ExerciseID)
+	}
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	return after.Version, tx.Commit()
+}
+
+func response(w http.ResponseWriter, httpStatus int, response *Response, err error) {
+	if err != nil {
+		response.Error = err.Error()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(httpStatus)
+	json.NewEncoder(w).Encode(response)
+}
+
+// ReportEndpoint function that reports a suspicious exercise of another user for review
+func ReportEndpoint(w http.ResponseWriter, r *http.Request) {
+	newResponse := &Response{}
+	request := &Request{}
+	params := mux.Vars(r)
+
+	defer r.Body.Close()
+
+	if err := json.NewDecoder(r.Body).Decode(request); err != nil {
+		response(w, http.StatusBadRequest, newResponse, err)
+		return
+	}
+
+	exerciseID, err := strconv.ParseInt(params["exerciseId"], 10, 64)
+	if err != nil {
+		response(w, http.StatusBadRequest, newResponse, ErrInvalidID)
+		return
+	}
+
+	if err = validateReason(request.Reason, true); err != nil {
+		response(w, http.StatusBadRequest, newResponse, err)
+		return
+	}
+
+	database, err := openDatabase()
+	if err != nil {
+		response(w, http.StatusInternalServerError, newResponse, err)
+		return
+	}
+
+	ownerID, err := getOwner(database, exerciseID)
+	if err == ErrNoExerciseFound {
+		response(w, http.StatusNotFound, newResponse, err)
+		return
+	}
+	if err != nil {
+		response(w, http.StatusInternalServerError, newResponse, err)
+		return
+	}
+
+	principal := auth.FromRequest(r)
+	if principal.UserID != 0 && principal.UserID == ownerID {
+		response(w, http.StatusBadRequest, newResponse, ErrOwnExercise)
+		return
+	}
+
+	newReport, err := report(database, exerciseID, principal.String(), request.Reason)
+	if err == ErrAlreadyReported {
+		response(w, http.StatusConflict, newResponse, err)
+		return
+	}
+	if err != nil {
+		response(w, http.StatusInternalServerError, newResponse, err)
+		return
+	}
+
+	newResponse.Report = newReport
+	response(w, http.StatusCreated, newResponse, err)
+}
+
+// QueueEndpoint function that lists the exercises waiting for review
+func QueueEndpoint(w http.ResponseWriter, r *http.Request) {
+	newResponse := &Response{}
+
+	database, err := openDatabase()
+	if err != nil {
+		response(w, http.StatusInternalServerError, newResponse, err)
+		return
+	}
+
+	queue, err := getQueue(database)
+	if err != nil {
+		response(w, http.StatusInternalServerError, newResponse, err)
+		return
+	}
+
+	newResponse.Queue = queue
+	response(w, http.StatusOK, newResponse, err)
+}
+
+func reviewEndpoint(w http.ResponseWriter, r *http.Request, decision Decision) {
+	newResponse := &Response{}
+	request := &Request{}
+	params := mux.Vars(r)
+
+	defer r.Body.Close()
+
+	if err := json.NewDecoder(r.Body).Decode(request); err != nil {
+		response(w, http.StatusBadRequest, newResponse, err)
+		return
+	}
+
+	exerciseID, err := strconv.ParseInt(params["exerciseId"], 10, 64)
+	if err != nil {
+		response(w, http.StatusBadRequest, newResponse, ErrInvalidID)
+		return
+	}
+
+	// the user is told why their exercise is not ranked
+	if err = validateReason(request.Reason, decision == RejectDecision); err != nil {
+		response(w, http.StatusBadRequest, newResponse, err)
+		return
+	}
+
+	database, err := openDatabase()
+	if err != nil {
+		response(w, http.StatusInternalServerError, newResponse, err)
+		return
+	}
+
+	newReview := &Review{
+		ExerciseID: exerciseID,
+		Decision:   decision,
+		Reason:     request.Reason,
+		Reviewer:   auth.FromRequest(r).String(),
+		CreatedAt:  time.Now().UTC(),
+	}
+
+	newVersion, err := review(database, newReview)
+	if err == ErrNoExerciseFound {
+		response(w, http.StatusNotFound, newResponse, err)
+		return
+	}
+	if err == ErrNotPending || err == archive.ErrArchivedExercise {
+		response(w, http.StatusConflict, newResponse, err)
+		return
+	}
+	if err != nil {
+		response(w, http.StatusInternalServerError, newResponse, err)
+		return
+	}
+
+	version.SetETag(w, exerciseID, newVersion)
+	newResponse.Review = newReview
+	response(w, http.StatusOK, newResponse, err)
+}
+
+// ApproveEndpoint function that ranks a flagged or reported exercise
+func ApproveEndpoint(w http.ResponseWriter, r *http.Request) {
+	reviewEndpoint(w, r, ApproveDecision)
+}
+
+// RejectEndpoint function that keeps a flagged or reported exercise out of the ranking
+func RejectEndpoint(w http.ResponseWriter, r *http.Request) {
+	reviewEndpoint(w, r, RejectDecision)
+}
@@ -0,0 +1,141 @@
+package notify
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	auth "../authenticate-request"
+	"github.com/gorilla/mux"
+)
+
+// Kind what a notification is about
+type Kind string
+
+var (
+	// ErrInvalidUserID Error when user id is not valid
+	ErrInvalidUserID = errors.New("Invalid user id")
+	// ErrInvalidLimit Error when limit param is not a positive number
+	ErrInvalidLimit = errors.New("Invalid param limit must be a positive number")
+)
+
+const (
+	// ExerciseApprovedKind a flagged or reported exercise was approved and is ranked
+	ExerciseApprovedKind Kind = "EXERCISE_APPROVED"
+	// ExerciseRejectedKind a flagged or reported exercise was rejected and is not ranked
+	ExerciseRejectedKind Kind = "EXERCISE_REJECTED"
+
+	defaultLimit = 50
+)
+
+// Notification message for a user
+type Notification struct {
+	ID         int64     `json:"id"`
+	UserID     int64     `json:"userId"`
+	Kind       Kind      `json:"kind"`
+	ExerciseID int64     `json:"exerciseId,omitempty"`
+	Message    string    `json:"message"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+// Queryer database or transaction the notifications are written to
+type Queryer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// Response for /users/{userId}/notifications
+type Response struct {
+	Notifications []*Notification `json:"notifications,omitempty"`
+	Error         string          `json:"error,omitempty"`
+}
+
+func openDatabase() (*sql.DB, error) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+
+	return sql.Open("sqlite3", fmt.Sprintf("%s/egym.db", dir))
+}
+
+// Send stores a notification for the user, along the changes it is about when given a transaction
+func Send(database Queryer, userID int64, kind Kind, exerciseID int64, message string) error {
+	_, err := database.Exec(`INSERT INTO notifications (USER_ID, KIND, EXERCISE_ID, MESSAGE, CREATED_AT) VALUES ($1, $2, $3, $4, $5)`, userID, kind, exerciseID, message, time.Now().UTC())
+	return err
+}
+
+func getNotifications(database *sql.DB, userID int64, limit int) ([]*Notification, error) {
+	result, err := database.Query(`SELECT ID, USER_ID, KIND, EXERCISE_ID, MESSAGE, CREATED_AT FROM notifications WHERE USER_ID=$1 ORDER BY ID DESC LIMIT $2`, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer result.Close()
+
+	notifications := []*Notification{}
+	for result.Next() {
+		notification := &Notification{}
+		if err := result.Scan(&notification.ID, &notification.UserID, &notification.Kind, &notification.ExerciseID, &notification.Message, &notification.CreatedAt); err != nil {
+			return nil, err
+		}
+
+		notifications = append(notifications, notification)
+	}
+
+	return notifications, result.Err()
+}
+
+func response(w http.ResponseWriter, httpStatus int, response *Response, err error) {
+	if err != nil {
+		response.Error = err.Error()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(httpStatus)
+	json.NewEncoder(w).Encode(response)
+}
+
+// NotificationsEndpoint function that returns the latest notifications of a user
+func NotificationsEndpoint(w http.ResponseWriter, r *http.Request) {
+	newResponse := &Response{}
+	params := mux.Vars(r)
+
+	userID, err := strconv.ParseInt(params["userId"], 10, 64)
+	if err != nil {
+		response(w, http.StatusBadRequest, newResponse, ErrInvalidUserID)
+		return
+	}
+
+	if !auth.FromRequest(r).CanActFor(userID) {
+		response(w, http.StatusForbidden, newResponse, auth.ErrForbidden)
+		return
+	}
+
+	limit := defaultLimit
+	if r.URL.Query().Get("limit") != "" {
+		limit, err = strconv.Atoi(r.URL.Query().Get("limit"))
+		if err != nil || limit < 1 {
+			response(w, http.StatusBadRequest, newResponse, ErrInvalidLimit)
+			return
+		}
+	}
+
+	database, err := openDatabase()
+	if err != nil {
+		response(w, http.StatusInternalServerError, newResponse, err)
+		return
+	}
+
+	notifications, err := getNotifications(database, userID, limit)
+	if err != nil {
+		response(w, http.StatusInternalServerError, newResponse, err)
+		return
+	}
+
+	newResponse.Notifications = notifications
+	response(w, http.StatusOK, newResponse, err)
+}
@@ -0,0 +1,455 @@
+package workout
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"time"
+)
+
+var (
+	// ErrInvalidFITHeader Error when the file does not start with a FIT header
+	ErrInvalidFITHeader = errors.New("Invalid FIT file header")
+	// ErrInvalidFITChecksum Error when the FIT file is corrupted
+	ErrInvalidFITChecksum = errors.New("Invalid FIT file checksum")
+	// ErrUndefinedFITMessage Error when a data message uses a local type without definition
+	ErrUndefinedFITMessage = errors.New("Invalid FIT file data message without definition")
+)
+
+// global numbers of the FIT messages read
+const (
+	fitSessionMessage = 18
+	fitLapMessage     = 19
+	fitRecordMessage  = 20
+)
+
+// field numbers of the FIT messages read
+const (
+	fitTimestampField        = 253
+	fitStartTimeField        = 2
+	fitTotalElapsedTimeField = 7
+	fitTotalDistanceField    = 9
+	fitTotalCaloriesField    = 11
+	fitSportField            = 5
+	fitSubSportField         = 6
+	fitAvgHeartRateField     = 16
+	fitMaxHeartRateField     = 17
+
+	fitPositionLatField      = 0
+	fitPositionLongField     = 1
+	fitAltitudeField         = 2
+	fitHeartRateField        = 3
+	fitDistanceField         = 5
+	fitEnhancedAltitudeField = 78
+)
+
+// fitEpoch FIT timestamps are seconds since this date
+var fitEpoch = time.Date(1989, time.December, 31, 0, 0, 0, 0, time.UTC)
+
+// fitCRCTable nibble table of the FIT CRC-16
+var fitCRCTable = [16]uint16{
+	0x0000, 0xCC01, 0xD801, 0x1400, 0xF001, 0x3C00, 0x2800, 0xE401,
+	0xA001, 0x6C00, 0x7800, 0xB401, 0x5000, 0x9C01, 0x8801, 0x4400,
+}
+
+// fitSports names of the FIT sport enum, training is refined by the sub sport
+var fitSports = map[uint64]string{
+	0:  "generic",
+	1:  "running",
+	2:  "cycling",
+	5:  "swimming",
+	10: "training",
+	11: "walking",
+	62: "hiit",
+}
+
+const (
+	fitTrainingSport            = 10
+	fitStrengthTrainingSubSport = 20
+)
+
+type fitField struct {
+	number   byte
+	size     byte
+	baseType byte
+}
+
+type fitDefinition struct {
+	byteOrder      binary.ByteOrder
+	globalMessage  uint16
+	fields         []fitField
+	developerBytes int
+}
+
+// fitMessage valid values of a data message by field number
+type fitMessage map[byte]uint64
+
+// fitReader reads the data records of a FIT file keeping the CRC of what was read
+type fitReader struct {
+	reader      *bufio.Reader
+	crc         uint16
+	remaining   uint32
+	definitions map[byte]*fitDefinition
+	timestamp   uint32
+}
+
+func fitCRC(crc uint16, data byte) uint16 {
+	tmp := fitCRCTable[crc&0xF]
+	crc = (crc >> 4) & 0x0FFF
+	crc = crc ^ tmp ^ fitCRCTable[data&0xF]
+
+	tmp = fitCRCTable[crc&0xF]
+	crc = (crc >> 4) & 0x0FFF
+
+	return crc ^ tmp ^ fitCRCTable[(data>>4)&0xF]
+}
+
+func (f *fitReader) read(size int) ([]byte, error) {
+	if uint32(size) > f.remaining {
+		return nil, ErrInvalidFile
+	}
+
+	data := make([]byte, size)
+	if _, err := io.ReadFull(f.reader, data); err != nil {
+		return nil, ErrInvalidFile
+	}
+
+	for _, b := range data {
+		f.crc = fitCRC(f.crc, b)
+	}
+	f.remaining -= uint32(size)
+
+	return data, nil
+}
+
+func (f *fitReader) readHeader() error {
+	size, err := f.reader.ReadByte()
+	if err != nil || (size != 12 && size != 14) {
+		return ErrInvalidFITHeader
+	}
+
+	header := make([]byte, size-1)
+	if _, err := io.ReadFull(f.reader, header); err != nil {
+		return ErrInvalidFITHeader
+	}
+
+	if string(header[7:11]) != ".FIT" {
+		return ErrInvalidFITHeader
+	}
+
+	// the file CRC covers the header too
+	f.crc = fitCRC(0, size)
+	for _, b := range header {
+		f.crc = fitCRC(f.crc, b)
+	}
+
+	f.remaining = binary.LittleEndian.Uint32(header[3:7])
+
+	return nil
+}
+
+func (f *fitReader) readDefinition(localType byte, hasDeveloperFields bool) error {
+	data, err := f.read(5)
+	if err != nil {
+		return err
+	}
+
+	definition := &fitDefinition{byteOrder: binary.LittleEndian}
+	if data[1] == 1 {
+		definition.byteOrder = binary.BigEndian
+	}
+	definition.globalMessage = definition.byteOrder.Uint16(data[2:4])
+
+	fields, err := f.read(int(data[4]) * 3)
+	if err != nil {
+		return err
+	}
+
+	for index := 0; index < len(fields); index += 3 {
+		definition.fields = append(definition.fields, fitField{number: fields[index], size: fields[index+1], baseType: fields[index+2]})
+	}
+
+	if hasDeveloperFields {
+		count, err := f.read(1)
+		if err != nil {
+			return err
+		}
+
+		developerFields, err := f.read(int(count[0]) * 3)
+		if err != nil {
+			return err
+		}
+
+		for index := 0; index < len(developerFields); index += 3 {
+			definition.developerBytes += int(developerFields[index+1])
+		}
+	}
+
+	f.definitions[localType] = definition
+
+	return nil
+}
+
+// fitValue decodes a single value of a field, false when it holds the invalid value of its base type
+func fitValue(field fitField, data []byte, byteOrder binary.ByteOrder) (uint64, bool) {
+	var value, invalid uint64
+
+	switch field.size {
+	case 1:
+		value, invalid = uint64(data[0]), math.MaxUint8
+	case 2:
+		value, invalid = uint64(byteOrder.Uint16(data)), math.MaxUint16
+	case 4:
+		value, invalid = uint64(byteOrder.Uint32(data)), math.MaxUint32
+	case 8:
+		value, invalid = byteOrder.Uint64(data), math.MaxUint64
+	default:
+		return 0, false
+	}
+
+	switch field.baseType & 0x1F {
+	case 0x01, 0x03, 0x05, 0x0E:
+		// signed types use the largest positive number as invalid
+		invalid >>= 1
+	case 0x0A, 0x0B, 0x0C, 0x10:
+		// z types use zero as invalid
+		invalid = 0
+	}
+
+	return value, value != invalid
+}
+
+func (f *fitReader) readData(definition *fitDefinition) (fitMessage, error) {
+	message := fitMessage{}
+
+	for _, field := range definition.fields {
+		data, err := f.read(int(field.size))
+		if err != nil {
+			return nil, err
+		}
+
+		if value, ok := fitValue(field, data, definition.byteOrder); ok {
+			message[field.number] = value
+		}
+	}
+
+	if _, err := f.read(definition.developerBytes); err != nil {
+		return nil, err
+	}
+
+	if timestamp, ok := message[fitTimestampField]; ok {
+		f.timestamp = uint32(timestamp)
+	}
+
+	return message, nil
+}
+
+// next reads records until a data message, nil when the data of the file is over
+func (f *fitReader) next() (*fitDefinition, fitMessage, error) {
+	for f.remaining > 0 {
+		header, err := f.read(1)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		// compressed timestamp header, the offset replaces the lowest 5 bits of the last timestamp
+		if header[0]&0x80 != 0 {
+			definition, ok := f.definitions[(header[0]>>5)&0x03]
+			if !ok {
+				return nil, nil, ErrUndefinedFITMessage
+			}
+
+			offset := uint32(header[0] & 0x1F)
+			timestamp := (f.timestamp &^ 0x1F) + offset
+			if offset < f.timestamp&0x1F {
+				timestamp += 0x20
+			}
+
+			message, err := f.readData(definition)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			f.timestamp = timestamp
+			message[fitTimestampField] = uint64(timestamp)
+
+			return definition, message, nil
+		}
+
+		localType := header[0] & 0x0F
+		if header[0]&0x40 != 0 {
+			if err := f.readDefinition(localType, header[0]&0x20 != 0); err != nil {
+				return nil, nil, err
+			}
+
+			continue
+		}
+
+		definition, ok := f.definitions[localType]
+		if !ok {
+			return nil, nil, ErrUndefinedFITMessage
+		}
+
+		message, err := f.readData(definition)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return definition, message, nil
+	}
+
+	return nil, nil, nil
+}
+
+func (f *fitReader) checkCRC() error {
+	checksum := make([]byte, 2)
+	if _, err := io.ReadFull(f.reader, checksum); err != nil {
+		return ErrInvalidFITChecksum
+	}
+
+	if binary.LittleEndian.Uint16(checksum) != f.crc {
+		return ErrInvalidFITChecksum
+	}
+
+	return nil
+}
+
+func fitTime(value uint64) time.Time {
+	return fitEpoch.Add(time.Duration(value) * time.Second)
+}
+
+// fitDegrees converts semicircles to degrees
+func fitDegrees(value uint64) float64 {
+	return float64(int32(uint32(value))) * 180 / math.Pow(2, 31)
+}
+
+func fitSport(sport uint64, subSport uint64) string {
+	if sport == fitTrainingSport && subSport == fitStrengthTrainingSubSport {
+		return "strength_training"
+	}
+
+	return fitSports[sport]
+}
+
+func (w *Workout) addFITRecord(message fitMessage) {
+	sample := &Sample{}
+
+	if timestamp, ok := message[fitTimestampField]; ok {
+		sample.Time = fitTime(timestamp)
+	}
+
+	latitude, hasLatitude := message[fitPositionLatField]
+	longitude, hasLongitude := message[fitPositionLongField]
+	if hasLatitude && hasLongitude {
+		sample.Latitude = fitDegrees(latitude)
+		sample.Longitude = fitDegrees(longitude)
+		sample.hasPosition = true
+	}
+
+	if altitude, ok := message[fitEnhancedAltitudeField]; ok {
+		sample.Altitude = float64(altitude)/5 - 500
+	} else if altitude, ok := message[fitAltitudeField]; ok {
+		sample.Altitude = float64(altitude)/5 - 500
+	}
+
+	if distance, ok := message[fitDistanceField]; ok {
+		sample.Distance = float64(distance) / 100
+	}
+
+	sample.HeartRate = int64(message[fitHeartRateField])
+
+	w.Samples = append(w.Samples, sample)
+}
+
+func (w *Workout) addFITLap(message fitMessage) {
+	w.Laps = append(w.Laps, &Lap{
+		StartTime: fitTime(message[fitStartTimeField]),
+		Duration:  int64(math.Round(float64(message[fitTotalElapsedTimeField]) / 1000)),
+		Distance:  float64(message[fitTotalDistanceField]) / 100,
+		Calories:  int64(message[fitTotalCaloriesField]),
+	})
+}
+
+// ParseFIT decodes the session, lap and record messages of a FIT activity file
+func ParseFIT(file io.Reader) (*Workout, error) {
+	f := &fitReader{reader: bufio.NewReader(file), definitions: map[byte]*fitDefinition{}}
+	if err := f.readHeader(); err != nil {
+		return nil, err
+	}
+
+	w := &Workout{}
+	var session fitMessage
+
+	for {
+		definition, message, err := f.next()
+		if err != nil {
+			return nil, err
+		}
+
+		if definition == nil {
+			break
+		}
+
+		switch definition.globalMessage {
+		case fitRecordMessage:
+			w.addFITRecord(message)
+		case fitLapMessage:
+			w.addFITLap(message)
+		case fitSessionMessage:
+			if session == nil {
+				session = message
+			}
+		}
+	}
+
+	if err := f.checkCRC(); err != nil {
+		return nil, err
+	}
+
+	if session != nil {
+		w.Sport = fitSport(session[fitSportField], session[fitSubSportField])
+		w.Calories = int64(session[fitTotalCaloriesField])
+	}
+
+	// a session without records still carries when it started and how long it lasted
+	if len(w.Samples) == 0 && session != nil {
+		startTime := fitTime(session[fitStartTimeField])
+		elapsed := time.Duration(session[fitTotalElapsedTimeField]) * time.Millisecond
+		w.Samples = append(w.Samples, &Sample{Time: startTime}, &Sample{Time: startTime.Add(elapsed)})
+	}
+
+	if err := w.summarize(); err != nil {
+		return nil, err
+	}
+
+	if session != nil {
+		if startTime, ok := session[fitStartTimeField]; ok {
+			w.StartTime = fitTime(startTime)
+		}
+
+		if elapsed, ok := session[fitTotalElapsedTimeField]; ok {
+			w.Duration = int64(math.Round(float64(elapsed) / 1000))
+		}
+
+		if heartRate, ok := session[fitAvgHeartRateField]; ok {
+			w.AverageHeartRate = int64(heartRate)
+		}
+
+		if heartRate, ok := session[fitMaxHeartRateField]; ok {
+			w.MaxHeartRate = int64(heartRate)
+		}
+	}
+
+	// the distance measured by the device is preferred to the one along the positions
+	w.Distance = float64(session[fitTotalDistanceField]) / 100
+	if w.Distance == 0 {
+		w.Distance = w.Samples[len(w.Samples)-1].Distance
+	}
+	if w.Distance == 0 {
+		w.Distance = trackDistance(w.Samples)
+	}
+
+	return w, nil
+}
@@ -0,0 +1,171 @@
+package workout
+
+import (
+	"bytes"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// expected values of a parsed workout, the distance compared to the meter
+type expected struct {
+	sport            string
+	exerciseType     string
+	startTime        time.Time
+	duration         int64
+	distance         float64
+	calories         int64
+	averageHeartRate int64
+	maxHeartRate     int64
+	laps             int
+	samples          int
+	hasPosition      bool
+}
+
+func readFixture(t *testing.T, name string) []byte {
+	t.Helper()
+
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return data
+}
+
+func checkWorkout(t *testing.T, w *Workout, want *expected) {
+	t.Helper()
+
+	if w.Sport != want.sport {
+		t.Errorf("sport = %q, want %q", w.Sport, want.sport)
+	}
+	if w.ExerciseType != want.exerciseType {
+		t.Errorf("type = %q, want %q", w.ExerciseType, want.exerciseType)
+	}
+	if !w.StartTime.Equal(want.startTime) {
+		t.Errorf("start time = %s, want %s", w.StartTime, want.startTime)
+	}
+	if w.Duration != want.duration {
+		t.Errorf("duration = %d, want %d", w.Duration, want.duration)
+	}
+	if math.Abs(w.Distance-want.distance) > 1 {
+		t.Errorf("distance = %f, want %f", w.Distance, want.distance)
+	}
+	if w.Calories != want.calories {
+		t.Errorf("calories = %d, want %d", w.Calories, want.calories)
+	}
+	if w.AverageHeartRate != want.averageHeartRate {
+		t.Errorf("average heart rate = %d, want %d", w.AverageHeartRate, want.averageHeartRate)
+	}
+	if w.MaxHeartRate != want.maxHeartRate {
+		t.Errorf("max heart rate = %d, want %d", w.MaxHeartRate, want.maxHeartRate)
+	}
+	if len(w.Laps) != want.laps {
+		t.Errorf("laps = %d, want %d", len(w.Laps), want.laps)
+	}
+	if len(w.Samples) != want.samples {
+		t.Fatalf("samples = %d, want %d", len(w.Samples), want.samples)
+	}
+	if w.Samples[0].HasPosition() != want.hasPosition {
+		t.Errorf("has position = %t, want %t", w.Samples[0].HasPosition(), want.hasPosition)
+	}
+}
+
+func TestParseFIT(t *testing.T) {
+	tests := []struct {
+		name    string
+		fixture string
+		data    []byte
+		want    *expected
+		err     error
+	}{
+		{
+			name:    "run with GPS",
+			fixture: "run_gps.fit",
+			want: &expected{
+				sport:            "running",
+				exerciseType:     "RUNNING",
+				startTime:        time.Date(2026, time.March, 1, 7, 0, 0, 0, time.UTC),
+				duration:         40,
+				distance:         100,
+				calories:         5,
+				averageHeartRate: 140,
+				maxHeartRate:     160,
+				laps:             1,
+				samples:          5,
+				hasPosition:      true,
+			},
+		},
+		{
+			name:    "indoor session without records",
+			fixture: "indoor_no_records.fit",
+			want: &expected{
+				sport:            "strength_training",
+				exerciseType:     "STRENGTH_TRAINING",
+				startTime:        time.Date(2026, time.March, 2, 18, 30, 0, 0, time.UTC),
+				duration:         1800,
+				calories:         250,
+				averageHeartRate: 110,
+				samples:          2,
+			},
+		},
+		{name: "corrupted checksum", fixture: "corrupted_crc.fit", err: ErrInvalidFITChecksum},
+		{name: "not a FIT file", data: []byte("<gpx></gpx>"), err: ErrInvalidFITHeader},
+		{name: "empty file", data: []byte{}, err: ErrInvalidFITHeader},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data := test.data
+			if test.fixture != "" {
+				data = readFixture(t, test.fixture)
+			}
+
+			w, err := ParseFIT(bytes.NewReader(data))
+			if err != test.err {
+				t.Fatalf("error = %v, want %v", err, test.err)
+			}
+
+			if test.want != nil {
+				checkWorkout(t, w, test.want)
+			}
+		})
+	}
+}
+
+func TestParseFITTruncated(t *testing.T) {
+	data := readFixture(t, "run_gps.fit")
+
+	if _, err := ParseFIT(bytes.NewReader(data[:len(data)/2])); err != ErrInvalidFile {
+		t.Fatalf("error = %v, want %v", err, ErrInvalidFile)
+	}
+}
+
+func TestParseFITSamples(t *testing.T) {
+	w, err := ParseFIT(bytes.NewReader(readFixture(t, "run_gps.fit")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the fourth record has a compressed timestamp header
+	start := time.Date(2026, time.March, 1, 7, 0, 0, 0, time.UTC)
+	for index, sample := range w.Samples {
+		if want := start.Add(time.Duration(index*10) * time.Second); !sample.Time.Equal(want) {
+			t.Errorf("sample %d time = %s, want %s", index, sample.Time, want)
+		}
+
+		if sample.Altitude != 600 {
+			t.Errorf("sample %d altitude = %f, want 600", index, sample.Altitude)
+		}
+	}
+
+	if latitude := w.Samples[0].Latitude; math.Abs(latitude-40) > 1e-6 {
+		t.Errorf("latitude = %f, want 40", latitude)
+	}
+
+	if longitude := w.Samples[0].Longitude; math.Abs(longitude+3) > 1e-6 {
+		t.Errorf("longitude = %f, want -3", longitude)
+	}
+}
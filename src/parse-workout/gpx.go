@@ -0,0 +1,69 @@
+package workout
+
+import (
+	"encoding/xml"
+	"io"
+	"time"
+)
+
+type gpxFile struct {
+	Tracks []gpxTrack `xml:"trk"`
+}
+
+type gpxTrack struct {
+	Name     string       `xml:"name"`
+	Type     string       `xml:"type"`
+	Segments []gpxSegment `xml:"trkseg"`
+}
+
+type gpxSegment struct {
+	Points []gpxPoint `xml:"trkpt"`
+}
+
+type gpxPoint struct {
+	Latitude  float64   `xml:"lat,attr"`
+	Longitude float64   `xml:"lon,attr"`
+	Elevation float64   `xml:"ele"`
+	Time      time.Time `xml:"time"`
+	HeartRate int64     `xml:"extensions>TrackPointExtension>hr"`
+}
+
+// ParseGPX decodes the tracks of a GPX 1.1 file, the heart rate is read from the Garmin track point extension
+func ParseGPX(file io.Reader) (*Workout, error) {
+	gpx := &gpxFile{}
+	if err := xml.NewDecoder(file).Decode(gpx); err != nil {
+		return nil, ErrInvalidFile
+	}
+
+	w := &Workout{}
+	for _, track := range gpx.Tracks {
+		if w.Name == "" {
+			w.Name = track.Name
+		}
+
+		if w.Sport == "" {
+			w.Sport = track.Type
+		}
+
+		for _, segment := range track.Segments {
+			for _, point := range segment.Points {
+				w.Samples = append(w.Samples, &Sample{
+					Time:        point.Time,
+					Latitude:    point.Latitude,
+					Longitude:   point.Longitude,
+					Altitude:    point.Elevation,
+					HeartRate:   point.HeartRate,
+					hasPosition: true,
+				})
+			}
+		}
+	}
+
+	if err := w.summarize(); err != nil {
+		return nil, err
+	}
+
+	w.Distance = trackDistance(w.Samples)
+
+	return w, nil
+}
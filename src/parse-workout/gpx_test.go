@@ -0,0 +1,58 @@
+package workout
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestParseGPX(t *testing.T) {
+	tests := []struct {
+		name    string
+		fixture string
+		data    string
+		want    *expected
+		err     error
+	}{
+		{
+			name:    "run",
+			fixture: "run.gpx",
+			want: &expected{
+				sport:            "running",
+				exerciseType:     "RUNNING",
+				startTime:        time.Date(2026, time.March, 1, 7, 0, 0, 0, time.UTC),
+				duration:         60,
+				distance:         200,
+				averageHeartRate: 140,
+				maxHeartRate:     161,
+				samples:          3,
+				hasPosition:      true,
+			},
+		},
+		{name: "not XML", data: "not xml", err: ErrInvalidFile},
+		{name: "without tracks", data: `<gpx version="1.1"></gpx>`, err: ErrNoTrackPoints},
+		{name: "points without time", data: `<gpx><trk><trkseg><trkpt lat="1" lon="1"></trkpt></trkseg></trk></gpx>`, err: ErrNoTrackPoints},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data := []byte(test.data)
+			if test.fixture != "" {
+				data = readFixture(t, test.fixture)
+			}
+
+			w, err := ParseGPX(bytes.NewReader(data))
+			if err != test.err {
+				t.Fatalf("error = %v, want %v", err, test.err)
+			}
+
+			if test.want != nil {
+				checkWorkout(t, w, test.want)
+
+				if w.Name != "Morning Run" {
+					t.Errorf("name = %q, want %q", w.Name, "Morning Run")
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package workout
+
+import (
+	"encoding/xml"
+	"io"
+	"math"
+	"time"
+)
+
+type tcxFile struct {
+	Activities []tcxActivity `xml:"Activities>Activity"`
+}
+
+type tcxActivity struct {
+	Sport string   `xml:"Sport,attr"`
+	Notes string   `xml:"Notes"`
+	Laps  []tcxLap `xml:"Lap"`
+}
+
+type tcxLap struct {
+	StartTime        time.Time       `xml:"StartTime,attr"`
+	TotalTimeSeconds float64         `xml:"TotalTimeSeconds"`
+	DistanceMeters   float64         `xml:"DistanceMeters"`
+	Calories         int64           `xml:"Calories"`
+	Trackpoints      []tcxTrackpoint `xml:"Track>Trackpoint"`
+}
+
+type tcxTrackpoint struct {
+	Time           time.Time    `xml:"Time"`
+	Position       *tcxPosition `xml:"Position"`
+	AltitudeMeters float64      `xml:"AltitudeMeters"`
+	DistanceMeters float64      `xml:"DistanceMeters"`
+	HeartRate      int64        `xml:"HeartRateBpm>Value"`
+}
+
+type tcxPosition struct {
+	Latitude  float64 `xml:"LatitudeDegrees"`
+	Longitude float64 `xml:"LongitudeDegrees"`
+}
+
+// ParseTCX decodes the first activity of a Training Center XML file
+func ParseTCX(file io.Reader) (*Workout, error) {
+	tcx := &tcxFile{}
+	if err := xml.NewDecoder(file).Decode(tcx); err != nil {
+		return nil, ErrInvalidFile
+	}
+
+	if len(tcx.Activities) == 0 {
+		return nil, ErrNoTrackPoints
+	}
+
+	activity := tcx.Activities[0]
+	w := &Workout{Name: activity.Notes, Sport: activity.Sport}
+	lapsDistance := 0.0
+
+	for _, lap := range activity.Laps {
+		w.Laps = append(w.Laps, &Lap{
+			StartTime: lap.StartTime,
+			Duration:  int64(math.Round(lap.TotalTimeSeconds)),
+			Distance:  lap.DistanceMeters,
+			Calories:  lap.Calories,
+		})
+
+		w.Calories += lap.Calories
+		lapsDistance += lap.DistanceMeters
+
+		for _, point := range lap.Trackpoints {
+			sample := &Sample{
+				Time:      point.Time,
+				Altitude:  point.AltitudeMeters,
+				Distance:  point.DistanceMeters,
+				HeartRate: point.HeartRate,
+			}
+
+			if point.Position != nil {
+				sample.Latitude = point.Position.Latitude
+				sample.Longitude = point.Position.Longitude
+				sample.hasPosition = true
+			}
+
+			w.Samples = append(w.Samples, sample)
+		}
+	}
+
+	// a lap without track points still carries when it started
+	if len(w.Samples) == 0 {
+		for _, lap := range w.Laps {
+			w.Samples = append(w.Samples, &Sample{Time: lap.StartTime}, &Sample{Time: lap.StartTime.Add(time.Duration(lap.Duration) * time.Second)})
+		}
+	}
+
+	if err := w.summarize(); err != nil {
+		return nil, err
+	}
+
+	// the distance measured by the device is preferred to the one along the positions
+	w.Distance = lapsDistance
+	if w.Distance == 0 {
+		w.Distance = w.Samples[len(w.Samples)-1].Distance
+	}
+	if w.Distance == 0 {
+		w.Distance = trackDistance(w.Samples)
+	}
+
+	return w, nil
+}
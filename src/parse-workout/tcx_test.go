@@ -0,0 +1,67 @@
+package workout
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestParseTCX(t *testing.T) {
+	tests := []struct {
+		name    string
+		fixture string
+		data    string
+		want    *expected
+		err     error
+	}{
+		{
+			name:    "run",
+			fixture: "run.tcx",
+			want: &expected{
+				sport:            "Running",
+				exerciseType:     "RUNNING",
+				startTime:        time.Date(2026, time.March, 1, 7, 0, 0, 0, time.UTC),
+				duration:         120,
+				distance:         390,
+				calories:         23,
+				averageHeartRate: 147,
+				maxHeartRate:     160,
+				laps:             2,
+				samples:          3,
+				hasPosition:      true,
+			},
+		},
+		{
+			name:    "lap without track points",
+			fixture: "strength_no_trackpoints.tcx",
+			want: &expected{
+				sport:     "Other",
+				startTime: time.Date(2026, time.March, 2, 18, 30, 0, 0, time.UTC),
+				duration:  1800,
+				calories:  250,
+				laps:      1,
+				samples:   2,
+			},
+		},
+		{name: "not XML", data: "not xml", err: ErrInvalidFile},
+		{name: "without activities", data: `<TrainingCenterDatabase><Activities></Activities></TrainingCenterDatabase>`, err: ErrNoTrackPoints},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data := []byte(test.data)
+			if test.fixture != "" {
+				data = readFixture(t, test.fixture)
+			}
+
+			w, err := ParseTCX(bytes.NewReader(data))
+			if err != test.err {
+				t.Fatalf("error = %v, want %v", err, test.err)
+			}
+
+			if test.want != nil {
+				checkWorkout(t, w, test.want)
+			}
+		})
+	}
+}
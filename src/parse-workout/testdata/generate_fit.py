@@ -0,0 +1,128 @@
+#!/usr/bin/env python3
+"""Writes the FIT fixtures of the tests, encoded apart from the decoder under test.
+
+Run from this directory: python3 generate_fit.py
+"""
+
+import struct
+from datetime import datetime, timezone
+
+FIT_EPOCH = datetime(1989, 12, 31, tzinfo=timezone.utc)
+
+# base types
+ENUM, UINT8, UINT16, UINT32, SINT32 = 0x00, 0x02, 0x84, 0x86, 0x85
+
+FORMATS = {ENUM: "B", UINT8: "B", UINT16: "H", UINT32: "I", SINT32: "i"}
+
+
+def crc16(data, crc=0):
+    """CRC-16 with the reflected 0x8005 polynomial the FIT protocol uses, computed bit by bit"""
+    for byte in data:
+        crc ^= byte
+        for _ in range(8):
+            crc = (crc >> 1) ^ 0xA001 if crc & 1 else crc >> 1
+    return crc
+
+
+def timestamp(text):
+    return int((datetime.fromisoformat(text) - FIT_EPOCH).total_seconds())
+
+
+def semicircles(degrees):
+    return int(round(degrees * 2 ** 31 / 180))
+
+
+class Encoder:
+    def __init__(self):
+        self.records = b""
+        self.definitions = {}
+
+    def define(self, local, global_message, fields, big_endian=False, developer_fields=()):
+        header = 0x40 | local | (0x20 if developer_fields else 0)
+        order = ">" if big_endian else "<"
+        record = struct.pack(order + "BBBHB", header, 0, 1 if big_endian else 0, global_message, len(fields))
+        for number, base_type in fields:
+            record += bytes([number, struct.calcsize(FORMATS[base_type]), base_type])
+        if developer_fields:
+            record += bytes([len(developer_fields)])
+            for number, size, index in developer_fields:
+                record += bytes([number, size, index])
+        self.records += record
+        self.definitions[local] = (order, fields, sum(size for _, size, _ in developer_fields))
+
+    def data(self, local, values, compressed_offset=None):
+        order, fields, developer_bytes = self.definitions[local]
+        if compressed_offset is None:
+            record = bytes([local])
+        else:
+            record = bytes([0x80 | (local << 5) | compressed_offset])
+        for (number, base_type), value in zip(fields, values):
+            record += struct.pack(order + FORMATS[base_type], value)
+        self.records += record + b"\x00" * developer_bytes
+
+    def encode(self):
+        header = struct.pack("<BBHI4s", 14, 0x20, 2132, len(self.records), b".FIT")
+        header += struct.pack("<H", crc16(header))
+        body = header + self.records
+        return body + struct.pack("<H", crc16(body))
+
+
+def run_with_gps():
+    start = timestamp("2026-03-01T07:00:00+00:00")
+    encoder = Encoder()
+
+    # file_id: type activity
+    encoder.define(0, 0, [(0, ENUM)])
+    encoder.data(0, [4])
+
+    # record with a developer field the decoder has to skip
+    encoder.define(1, 20, [(253, UINT32), (0, SINT32), (1, SINT32), (2, UINT16), (3, UINT8), (5, UINT32)], developer_fields=[(0, 2, 0)])
+    # record without timestamp, sent with a compressed timestamp header
+    encoder.define(2, 20, [(0, SINT32), (1, SINT32), (2, UINT16), (3, UINT8), (5, UINT32)])
+
+    for index in range(5):
+        latitude = semicircles(40.0 + index * 0.0002)
+        longitude = semicircles(-3.0)
+        altitude = (600 + 500) * 5
+        heart_rate = 120 + index * 10
+        distance = index * 2500
+        if index == 3:
+            encoder.data(2, [latitude, longitude, altitude, heart_rate, distance], compressed_offset=(start + 30) & 0x1F)
+        else:
+            encoder.data(1, [start + index * 10, latitude, longitude, altitude, heart_rate, distance])
+
+    # lap: timestamp, start time, total elapsed time, total distance, total calories
+    encoder.define(3, 19, [(253, UINT32), (2, UINT32), (7, UINT32), (9, UINT32), (11, UINT16)])
+    encoder.data(3, [start + 40, start, 40000, 10000, 5])
+
+    # session: timestamp, start time, total elapsed time, total distance, total calories, sport, sub sport, heart rate
+    encoder.define(4, 18, [(253, UINT32), (2, UINT32), (7, UINT32), (9, UINT32), (11, UINT16), (5, ENUM), (6, ENUM), (16, UINT8), (17, UINT8)])
+    encoder.data(4, [start + 40, start, 40000, 10000, 5, 1, 0, 140, 160])
+
+    return encoder.encode()
+
+
+def indoor_without_records():
+    start = timestamp("2026-03-02T18:30:00+00:00")
+    encoder = Encoder()
+
+    encoder.define(0, 0, [(0, ENUM)], big_endian=True)
+    encoder.data(0, [4])
+
+    # session of a strength training, big endian, without distance nor records
+    encoder.define(1, 18, [(253, UINT32), (2, UINT32), (7, UINT32), (9, UINT32), (11, UINT16), (5, ENUM), (6, ENUM), (16, UINT8), (17, UINT8)], big_endian=True)
+    encoder.data(1, [start + 1800, start, 1800000, 0xFFFFFFFF, 250, 10, 20, 110, 0xFF])
+
+    return encoder.encode()
+
+
+def corrupted_crc():
+    data = bytearray(run_with_gps())
+    data[-1] ^= 0xFF
+    return bytes(data)
+
+
+if __name__ == "__main__":
+    for name, data in [("run_gps.fit", run_with_gps()), ("indoor_no_records.fit", indoor_without_records()), ("corrupted_crc.fit", corrupted_crc())]:
+        with open(name, "wb") as file:
+            file.write(data)
@@ -0,0 +1,183 @@
+package workout
+
+import (
+	"errors"
+	"io"
+	"math"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrUnsupportedFormat Error when the file is not a GPX, TCX or FIT file
+	ErrUnsupportedFormat = errors.New("Unsupported file format must be .gpx, .tcx or .fit")
+	// ErrInvalidFile Error when the file can not be decoded
+	ErrInvalidFile = errors.New("Invalid workout file")
+	// ErrNoTrackPoints Error when the file has no timed track points to derive the workout from
+	ErrNoTrackPoints = errors.New("The workout file has no track points with time")
+)
+
+const earthRadius = 6371000.0
+
+// sportTypes exercise type of the sports named by GPS devices
+var sportTypes = map[string]string{
+	"running":             "RUNNING",
+	"run":                 "RUNNING",
+	"trail_running":       "RUNNING",
+	"treadmill_running":   "RUNNING",
+	"swimming":            "SWIMMING",
+	"swim":                "SWIMMING",
+	"lap_swimming":        "SWIMMING",
+	"open_water_swimming": "SWIMMING",
+	"strength_training":   "STRENGTH_TRAINING",
+	"circuit_training":    "CIRCUIT_TRAINING",
+	"hiit":                "CIRCUIT_TRAINING",
+}
+
+// Sample point recorded by the device
+type Sample struct {
+	Time        time.Time `json:"time"`
+	Latitude    float64   `json:"latitude,omitempty"`
+	Longitude   float64   `json:"longitude,omitempty"`
+	Altitude    float64   `json:"altitude,omitempty"`
+	Distance    float64   `json:"distance,omitempty"`
+	HeartRate   int64     `json:"heartRate,omitempty"`
+	hasPosition bool
+}
+
+// HasPosition whether the sample was recorded with GPS
+func (s *Sample) HasPosition() bool {
+	return s.hasPosition
+}
+
+// Lap split of the workout as recorded by the device
+type Lap struct {
+	StartTime time.Time `json:"startTime"`
+	Duration  int64     `json:"duration"`
+	Distance  float64   `json:"distance"`
+	Calories  int64     `json:"calories,omitempty"`
+}
+
+// Workout values derived from a recorded activity
+type Workout struct {
+	// Name given to the activity on the device
+	Name string `json:"name,omitempty"`
+	// Sport as named by the device
+	Sport string `json:"sport,omitempty"`
+	// ExerciseType the sport maps to, empty when it has no equivalent
+	ExerciseType string `json:"type,omitempty"`
+	// StartTime time of the first sample
+	StartTime time.Time `json:"startTime"`
+	// Duration seconds between the first and the last sample
+	Duration int64 `json:"duration"`
+	// Distance meters covered
+	Distance float64 `json:"distance"`
+	// Calories burnt, only known when the device records them
+	Calories int64 `json:"calories,omitempty"`
+	// AverageHeartRate beats per minute
+	AverageHeartRate int64 `json:"averageHeartRate,omitempty"`
+	// MaxHeartRate beats per minute
+	MaxHeartRate int64 `json:"maxHeartRate,omitempty"`
+	// Laps recorded by the device
+	Laps []*Lap `json:"laps,omitempty"`
+	// Samples raw points of the activity
+	Samples []*Sample `json:"-"`
+}
+
+// Parse decodes a workout file, choosing the format by the extension of its name
+func Parse(filename string, file io.Reader) (*Workout, error) {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".gpx":
+		return ParseGPX(file)
+	case ".tcx":
+		return ParseTCX(file)
+	case ".fit":
+		return ParseFIT(file)
+	default:
+		return nil, ErrUnsupportedFormat
+	}
+}
+
+// ExerciseType exercise type of the sport named by a device
+func ExerciseType(sport string) string {
+	key := strings.ToLower(strings.TrimSpace(sport))
+	key = strings.NewReplacer(" ", "_", "-", "_").Replace(key)
+
+	return sportTypes[key]
+}
+
+func toRadians(degrees float64) float64 {
+	return degrees * math.Pi / 180
+}
+
+// haversine meters between two points of the earth surface
+func haversine(from *Sample, to *Sample) float64 {
+	deltaLatitude := toRadians(to.Latitude - from.Latitude)
+	deltaLongitude := toRadians(to.Longitude - from.Longitude)
+
+	a := math.Sin(deltaLatitude/2)*math.Sin(deltaLatitude/2) +
+		math.Cos(toRadians(from.Latitude))*math.Cos(toRadians(to.Latitude))*math.Sin(deltaLongitude/2)*math.Sin(deltaLongitude/2)
+
+	return earthRadius * 2 * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
+}
+
+// trackDistance meters along the samples with position
+func trackDistance(samples []*Sample) float64 {
+	distance := 0.0
+	var previous *Sample
+
+	for _, sample := range samples {
+		if !sample.hasPosition {
+			continue
+		}
+
+		if previous != nil {
+			distance += haversine(previous, sample)
+		}
+
+		previous = sample
+	}
+
+	return distance
+}
+
+// summarize derives start time, duration and heart rate from the samples
+func (w *Workout) summarize() error {
+	timed := []*Sample{}
+	for _, sample := range w.Samples {
+		if !sample.Time.IsZero() {
+			timed = append(timed, sample)
+		}
+	}
+
+	if len(timed) == 0 {
+		return ErrNoTrackPoints
+	}
+
+	w.Samples = timed
+	w.StartTime = timed[0].Time
+	w.Duration = int64(timed[len(timed)-1].Time.Sub(w.StartTime) / time.Second)
+
+	var total, count int64
+	for _, sample := range timed {
+		if sample.HeartRate == 0 {
+			continue
+		}
+
+		total += sample.HeartRate
+		count++
+
+		if sample.HeartRate > w.MaxHeartRate {
+			w.MaxHeartRate = sample.HeartRate
+		}
+	}
+
+	if count > 0 {
+		w.AverageHeartRate = int64(math.Round(float64(total) / float64(count)))
+	}
+
+	w.ExerciseType = ExerciseType(w.Sport)
+
+	return nil
+}
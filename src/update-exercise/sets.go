@@ -0,0 +1,240 @@
+package update
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+
+	auth "../authenticate-request"
+	archive "../exercise-archive"
+	history "../exercise-history"
+	version "../exercise-version"
+	sets "../manage-sets"
+	"github.com/gorilla/mux"
+)
+
+const strengthTrainingType = "STRENGTH_TRAINING"
+
+// changeSets modifies the sets of an exercise in the transaction of the new version
+type changeSets func(tx *sql.Tx, exerciseID int64) error
+
+func setsResponse(w http.ResponseWriter, httpStatus int, response *sets.Response, err error) {
+	if err != nil {
+		response.Error = err.Error()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(httpStatus)
+	json.NewEncoder(w).Encode(response)
+}
+
+func setsStatusOf(err error) int {
+	switch err {
+	case ErrInvalidID:
+		return http.StatusBadRequest
+	case ErrNoExerciseFound:
+		return http.StatusNotFound
+	case auth.ErrForbidden:
+		return http.StatusForbidden
+	case archive.ErrArchivedExercise:
+		return http.StatusConflict
+	case version.ErrPreconditionRequired, version.ErrPreconditionFailed:
+		return version.Status(err)
+	case ErrDatabaseError:
+		return http.StatusInternalServerError
+	default:
+		return sets.StatusOf(err)
+	}
+}
+
+// updateSets applies the change and increments the version of the exercise, recording both states in its history
+func updateSets(exerciseID int64, expectedVersion int64, actor string, change changeSets) (*history.State, error) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	database, err := sql.Open("sqlite3", fmt.Sprintf("%s/egym.db", dir))
+	if err != nil {
+		return nil, ErrDatabaseError
+	}
+
+	tx, err := database.Begin()
+	if err != nil {
+		return nil, ErrDatabaseError
+	}
+
+	before, err := history.Load(tx, exerciseID)
+	if err == nil && before == nil {
+		err = ErrNoExerciseFound
+	}
+	if err == nil {
+		err = archive.Check(tx, exerciseID)
+	}
+	if err == nil {
+		err = change(tx, exerciseID)
+	}
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	result, err := tx.Exec(`UPDATE exercises SET VERSION=VERSION+1 WHERE ID=$1 AND VERSION=$2`, exerciseID, expectedVersion)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	// another request modified the exercise after its version was checked
+	updated, err := result.RowsAffected()
+	if err == nil && updated == 0 {
+		err = version.ErrPreconditionFailed
+	}
+
+	var after *history.State
+	if err == nil {
+		after, err = history.Load(tx, exerciseID)
+	}
+	if err == nil {
+		err = history.Record(tx, exerciseID, history.UpdateAction, actor, before, after)
+	}
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	return after, tx.Commit()
+}
+
+// saveSets checks the principal can modify the strength training exercise of the request at the version it read and applies the change
+func saveSets(w http.ResponseWriter, r *http.Request, httpStatus int, newResponse *sets.Response, change changeSets) {
+	exerciseID, err := strconv.ParseInt(mux.Vars(r)["exerciseId"], 10, 64)
+	if err != nil {
+		setsResponse(w, http.StatusBadRequest, newResponse, ErrInvalidID)
+		return
+	}
+
+	stored, err := getStoredExercise(exerciseID)
+	if err != nil {
+		setsResponse(w, setsStatusOf(err), newResponse, err)
+		return
+	}
+
+	if !auth.FromRequest(r).CanActFor(stored.UserID) {
+		setsResponse(w, http.StatusForbidden, newResponse, auth.ErrForbidden)
+		return
+	}
+
+	if stored.ExerciseType != strengthTrainingType {
+		setsResponse(w, http.StatusConflict, newResponse, sets.ErrUnwantedSets)
+		return
+	}
+
+	if err = version.CheckIfMatch(r, exerciseID, stored.Version); err != nil {
+		setsResponse(w, version.Status(err), newResponse, err)
+		return
+	}
+
+	after, err := updateSets(exerciseID, stored.Version, auth.FromRequest(r).String(), change)
+	if err != nil {
+		setsResponse(w, setsStatusOf(err), newResponse, err)
+		return
+	}
+
+	version.SetETag(w, exerciseID, after.Version)
+	newResponse.Sets = after.Sets
+	newResponse.Volume = sets.Volume(after.Sets)
+	setsResponse(w, httpStatus, newResponse, nil)
+}
+
+// ReplaceSetsEndpoint function that replaces every set of an exercise
+func ReplaceSetsEndpoint(w http.ResponseWriter, r *http.Request) {
+	newResponse := &sets.Response{}
+	exerciseSets := []*sets.Set{}
+
+	defer r.Body.Close()
+
+	if err := json.NewDecoder(r.Body).Decode(&exerciseSets); err != nil {
+		setsResponse(w, http.StatusBadRequest, newResponse, err)
+		return
+	}
+
+	if err := sets.Validate(strengthTrainingType, exerciseSets); err != nil {
+		setsResponse(w, sets.StatusOf(err), newResponse, err)
+		return
+	}
+
+	saveSets(w, r, http.StatusOK, newResponse, func(tx *sql.Tx, exerciseID int64) error {
+		return sets.Replace(tx, exerciseID, exerciseSets)
+	})
+}
+
+// AddSetEndpoint function that appends a set to an exercise
+func AddSetEndpoint(w http.ResponseWriter, r *http.Request) {
+	newResponse := &sets.Response{}
+	set := &sets.Set{}
+
+	defer r.Body.Close()
+
+	if err := json.NewDecoder(r.Body).Decode(set); err != nil {
+		setsResponse(w, http.StatusBadRequest, newResponse, err)
+		return
+	}
+
+	if err := set.Validate(); err != nil {
+		setsResponse(w, sets.StatusOf(err), newResponse, err)
+		return
+	}
+
+	newResponse.Set = set
+	saveSets(w, r, http.StatusCreated, newResponse, func(tx *sql.Tx, exerciseID int64) error {
+		return sets.Append(tx, exerciseID, set)
+	})
+}
+
+// UpdateSetEndpoint function that modifies a set of an exercise
+func UpdateSetEndpoint(w http.ResponseWriter, r *http.Request) {
+	newResponse := &sets.Response{}
+	set := &sets.Set{}
+
+	defer r.Body.Close()
+
+	if err := json.NewDecoder(r.Body).Decode(set); err != nil {
+		setsResponse(w, http.StatusBadRequest, newResponse, err)
+		return
+	}
+
+	setID, err := strconv.ParseInt(mux.Vars(r)["setId"], 10, 64)
+	if err != nil {
+		setsResponse(w, http.StatusBadRequest, newResponse, sets.ErrInvalidID)
+		return
+	}
+	set.ID = setID
+
+	if err = set.Validate(); err != nil {
+		setsResponse(w, sets.StatusOf(err), newResponse, err)
+		return
+	}
+
+	newResponse.Set = set
+	saveSets(w, r, http.StatusOK, newResponse, func(tx *sql.Tx, exerciseID int64) error {
+		return sets.Update(tx, exerciseID, set)
+	})
+}
+
+// DeleteSetEndpoint function that removes a set of an exercise
+func DeleteSetEndpoint(w http.ResponseWriter, r *http.Request) {
+	newResponse := &sets.Response{}
+
+	setID, err := strconv.ParseInt(mux.Vars(r)["setId"], 10, 64)
+	if err != nil {
+		setsResponse(w, http.StatusBadRequest, newResponse, sets.ErrInvalidID)
+		return
+	}
+
+	saveSets(w, r, http.StatusOK, newResponse, func(tx *sql.Tx, exerciseID int64) error {
+		return sets.Delete(tx, exerciseID, setID)
+	})
+}
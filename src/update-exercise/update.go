@@ -7,10 +7,22 @@ import (
 	"fmt"
 	"net/http"
 	"os"
-	"regexp"
 	"strconv"
 	"time"
 
+	auth "../authenticate-request"
+	archive "../exercise-archive"
+	calories "../exercise-calories"
+	history "../exercise-history"
+	metrics "../exercise-metrics"
+	plausibility "../exercise-plausibility"
+	records "../exercise-records"
+	text "../exercise-text"
+	version "../exercise-version"
+	circuits "../manage-circuits"
+	sets "../manage-sets"
+	swims "../manage-swims"
+	tags "../manage-tags"
 	"github.com/gorilla/mux"
 )
 
@@ -26,20 +38,18 @@ var (
 	ErrUnwantedUserID = errors.New("Unwanted userId field received")
 	// ErrMissingDescription Error when description field is not received
 	ErrMissingDescription = errors.New("Missing description")
-	// ErrInvalidDescription Error when description field is not received
-	ErrInvalidDescription = errors.New("Invalid description not an alphanumeric string")
 	// ErrUnwantedType Error when type field is received
 	ErrUnwantedType = errors.New("Unwanted type field received")
 	// ErrInvalidType Error when type field is invalid
 	ErrInvalidType = errors.New("Invalid type")
 	// ErrMissingStartTime Error when startTime field is not received
 	ErrMissingStartTime = errors.New("Missing startTime")
+	// ErrInvalidTimezone Error when timezone field is not an IANA time zone
+	ErrInvalidTimezone = errors.New("Invalid timezone must be an IANA time zone")
 	// ErrInvalidStartTime Error when startTime field is invalid
 	ErrInvalidStartTime = errors.New("Invalid startTime format must be ISO8601")
 	// ErrMissingDuration Error when duration field is not received
 	ErrMissingDuration = errors.New("Missing duration")
-	// ErrMissingCalories Error when calories field is not received
-	ErrMissingCalories = errors.New("Missing calories")
 	// ErrInvalidExercise Error when total points calculated for an exercise returns 0
 	ErrInvalidExercise = errors.New("Invalid exercise as to total points calculation equals 0")
 	// ErrExerciseOverlapping Error when a new exercise overlaps a saved one
@@ -50,33 +60,76 @@ var (
 	ErrNoExerciseFound = errors.New("The exercise you tried to update does not exists")
 )
 
+// Patch Request structure of a partial update, omitted fields keep their stored value
+type Patch struct {
+	UserID       *int64              `json:"userId"`
+	Description  *string             `json:"description"`
+	Notes        *string             `json:"notes"`
+	Tags         *[]string           `json:"tags"`
+	ExerciseType *ExerciseType       `json:"type"`
+	StartTime    *time.Time          `json:"startTime"`
+	Timezone     *string             `json:"timezone"`
+	Duration     *int64              `json:"duration"`
+	Calories     *int64              `json:"calories"`
+	Intensity    *calories.Intensity `json:"intensity"`
+	Distance     *float64            `json:"distance"`
+	Sets         *[]*sets.Set        `json:"sets"`
+	Circuit      *circuits.Circuit   `json:"circuit"`
+	Swim         *swims.Swim         `json:"swim"`
+}
+
 // Exercise structure and Request structure
 type Exercise struct {
 	// UserID id field of User
 	UserID int64 `json:"userId"`
-	// Description of the Exercise
+	// Description of the Exercise, in any language
 	Description string `json:"description"`
+	// Notes free text about the exercise, cleared when not received
+	Notes string `json:"notes,omitempty"`
+	// Tags labels of the exercise, the stored ones are kept when not received
+	Tags []string `json:"tags,omitempty"`
 	// ExerciseType type of the exercise
 	ExerciseType ExerciseType `json:"type"`
-	// StartTime time when exercise starts
+	// StartTime time when exercise starts, stored in UTC
 	StartTime time.Time `json:"startTime"`
+	// Timezone IANA time zone the exercise took place in, the stored one when not received
+	Timezone string `json:"timezone,omitempty"`
 	// Duration duration of the exercise
 	Duration int64 `json:"duration"`
-	// Calories burnt on the exercise
+	// Calories burnt on the exercise, estimated from the intensity when not received
 	Calories int64 `json:"calories"`
+	// CaloriesEstimated whether the calories were estimated instead of measured
+	CaloriesEstimated bool `json:"caloriesEstimated,omitempty"`
+	// Intensity of the exercise, LOW, MODERATE or HIGH, the calories are estimated with
+	Intensity calories.Intensity `json:"intensity,omitempty"`
+	// Distance meters covered on the exercise
+	Distance float64 `json:"distance,omitempty"`
+	// Metrics speed and pace derived from the distance
+	Metrics *metrics.Metrics `json:"metrics,omitempty"`
+	// Status ACCEPTED or APPROVED when ranked, FLAGGED until reviewed, REJECTED when not ranked
+	Status plausibility.Status `json:"status,omitempty"`
+	// FlagReason rule of the type the exercise breaks when flagged
+	FlagReason string `json:"flagReason,omitempty"`
+	// Sets performed on a strength training exercise, the stored ones are kept when not received
+	Sets []*sets.Set `json:"sets,omitempty"`
+	// Volume kilograms lifted on the sets
+	Volume float64 `json:"volume,omitempty"`
+	// Circuit stations and rounds of a circuit training exercise, the stored one is kept when not received
+	Circuit *circuits.Circuit `json:"circuit,omitempty"`
+	// Swim pool length, laps and strokes of a swimming exercise, the stored one is kept when not received
+	Swim *swims.Swim `json:"swim,omitempty"`
+	// Version incremented on every modification, sent as ETag
+	Version int64 `json:"-"`
+	// newRecords personal records beaten by the modified exercise
+	newRecords []*records.Record
 }
 
 // Response for /exercise
 type Response struct {
 	Exercise *Exercise `json:"exercise,omitempty"`
-	Error    string    `json:"error,omitempty"`
-}
-
-func isAlphaNumericString(description string) bool {
-	AlphaNumericStringRegex := `^[A-Za-z0-9\s]+$`
-	AlphaNumericRegex := regexp.MustCompile(AlphaNumericStringRegex)
-
-	return AlphaNumericRegex.MatchString(description)
+	// NewRecords personal records beaten by the modified exercise
+	NewRecords []*records.Record `json:"newRecords,omitempty"`
+	Error      string            `json:"error,omitempty"`
 }
 
 func addDurationToDate(date time.Time, duration int64) time.Time {
@@ -123,8 +176,18 @@ func (e *Exercise) validateUpdateExerciseRequest(ID int64) error {
 		return ErrMissingDescription
 	}
 
-	if !isAlphaNumericString(e.Description) {
-		return ErrInvalidDescription
+	description, err := text.Description(e.Description)
+	if err != nil {
+		return err
+	}
+	e.Description = description
+
+	if e.Notes, err = text.Notes(e.Notes); err != nil {
+		return err
+	}
+
+	if e.Tags, err = tags.Validate(e.Tags); err != nil {
+		return err
 	}
 
 	if e.ExerciseType != "" {
@@ -135,12 +198,21 @@ func (e *Exercise) validateUpdateExerciseRequest(ID int64) error {
 		return ErrMissingStartTime
 	}
 
+	// the offset sent is not kept, the time zone of the exercise is
+	e.StartTime = e.StartTime.UTC()
+
+	if e.Timezone != "" {
+		if _, err := time.LoadLocation(e.Timezone); err != nil {
+			return ErrInvalidTimezone
+		}
+	}
+
 	if e.Duration == 0 {
 		return ErrMissingDuration
 	}
 
-	if e.Calories == 0 {
-		return ErrMissingCalories
+	if err := calories.ValidateIntensity(e.Intensity); err != nil {
+		return err
 	}
 
 	finishDate := addDurationToDate(e.StartTime, e.Duration)
@@ -152,7 +224,131 @@ func (e *Exercise) validateUpdateExerciseRequest(ID int64) error {
 	return nil
 }
 
-func (e *Exercise) updateExercise(ID int64) error {
+func getStoredExercise(ID int64) (*history.State, error) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	database, err := sql.Open("sqlite3", fmt.Sprintf("%s/egym.db", dir))
+	if err != nil {
+		return nil, ErrDatabaseError
+	}
+
+	stored, err := history.Load(database, ID)
+	if err != nil {
+		return nil, ErrDatabaseError
+	}
+
+	if stored == nil {
+		return nil, ErrNoExerciseFound
+	}
+
+	return stored, nil
+}
+
+func getStoredCircuit(ID int64) (*circuits.Circuit, error) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	database, err := sql.Open("sqlite3", fmt.Sprintf("%s/egym.db", dir))
+	if err != nil {
+		return nil, ErrDatabaseError
+	}
+
+	circuit, err := circuits.Load(database, ID)
+	if err != nil {
+		return nil, ErrDatabaseError
+	}
+
+	return circuit, nil
+}
+
+func getStoredSwim(ID int64) (*swims.Swim, error) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	database, err := sql.Open("sqlite3", fmt.Sprintf("%s/egym.db", dir))
+	if err != nil {
+		return nil, ErrDatabaseError
+	}
+
+	swim, err := swims.Load(database, ID, 0)
+	if err != nil {
+		return nil, ErrDatabaseError
+	}
+
+	return swim, nil
+}
+
+// checkPlausibility of the values against the rules of the stored type, flagging the exercise when they are broken
+func (e *Exercise) checkPlausibility(ID int64, stored *history.State) error {
+	dir, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	database, err := sql.Open("sqlite3", fmt.Sprintf("%s/egym.db", dir))
+	if err != nil {
+		return ErrDatabaseError
+	}
+
+	e.FlagReason, err = plausibility.Check(database, stored.ExerciseType, e.StartTime, e.Duration, e.Calories, e.Distance)
+	if err != nil {
+		return err
+	}
+
+	var status plausibility.Status
+	err = database.QueryRow(`SELECT STATUS FROM exercises WHERE ID=$1`, ID).Scan(&status)
+	if err != nil {
+		return ErrDatabaseError
+	}
+
+	e.Status = plausibility.Revise(status, e.FlagReason)
+
+	return nil
+}
+
+// estimateCalories from the MET of the stored type at the intensity of the exercise and the weight of the user
+func (e *Exercise) estimateCalories(stored *history.State) error {
+	dir, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	database, err := sql.Open("sqlite3", fmt.Sprintf("%s/egym.db", dir))
+	if err != nil {
+		return ErrDatabaseError
+	}
+
+	e.Calories, err = calories.Estimate(database, stored.UserID, stored.ExerciseType, e.Intensity, e.Duration)
+	if err != nil {
+		return err
+	}
+
+	e.CaloriesEstimated = true
+
+	return nil
+}
+
+// checkArchived verifies both the stored and the new start time stay out of archived seasons
+func checkArchived(ID int64, startDate time.Time) error {
+	dir, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	database, err := sql.Open("sqlite3", fmt.Sprintf("%s/egym.db", dir))
+	if err != nil {
+		return ErrDatabaseError
+	}
+
+	if err = archive.Check(database, ID); err != nil {
+		return err
+	}
+
+	return archive.CheckStartTime(database, startDate)
+}
+
+func (e *Exercise) updateExercise(ID int64, expectedVersion int64, actor string) error {
 	finishDate := addDurationToDate(e.StartTime, e.Duration)
 
 	dir, err := os.Getwd()
@@ -164,29 +360,95 @@ func (e *Exercise) updateExercise(ID int64) error {
 		return ErrDatabaseError
 	}
 
-	sqlStatement := `SELECT COUNT(*) FROM exercises WHERE ID=$1;`
-	var numberOfElements int64
-	_ = database.QueryRow(sqlStatement, ID).Scan(&numberOfElements)
-	if numberOfElements == 0 {
+	tx, err := database.Begin()
+	if err != nil {
+		return ErrDatabaseError
+	}
+
+	before, err := history.Load(tx, ID)
+	if err != nil {
+		tx.Rollback()
+		return ErrDatabaseError
+	}
+
+	if before == nil {
+		tx.Rollback()
 		return ErrNoExerciseFound
 	}
 
-	statement, err := database.Prepare("UPDATE exercises SET DESCRIPTION=$1, START_TIME=$2, FINISH_TIME=$3, DURATION=$4, CALORIES=$5 WHERE ID=$6")
+	statement, err := tx.Prepare("UPDATE exercises SET DESCRIPTION=$1, NOTES=$2, START_TIME=$3, FINISH_TIME=$4, TIMEZONE=$5, DURATION=$6, CALORIES=$7, CALORIES_ESTIMATED=$8, INTENSITY=$9, DISTANCE=$10, STATUS=$11, FLAG_REASON=$12, VERSION=VERSION+1 WHERE ID=$13 AND VERSION=$14")
 	if err != nil {
+		tx.Rollback()
 		return ErrDatabaseError
 	}
 
-	_, err = statement.Exec(e.Description, e.StartTime, finishDate, e.Duration, e.Calories, ID)
+	result, err := statement.Exec(e.Description, e.Notes, e.StartTime, finishDate, e.Timezone, e.Duration, e.Calories, e.CaloriesEstimated, e.Intensity, e.Distance, e.Status, e.FlagReason, ID, expectedVersion)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	// another request modified the exercise after its version was checked
+	updated, err := result.RowsAffected()
+	if err == nil && updated == 0 {
+		err = version.ErrPreconditionFailed
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if e.Circuit != nil {
+		err = circuits.Save(tx, ID, e.Circuit)
+	}
+
+	if err == nil && e.Swim != nil {
+		err = swims.Save(tx, ID, e.Swim)
+	}
+
+	if err == nil && e.Sets != nil {
+		err = sets.Replace(tx, ID, e.Sets)
+	}
+
+	if err == nil && e.Tags != nil {
+		err = tags.Save(tx, ID, e.Tags)
+	}
+
+	// the stored tags are kept when none are received
+	if err == nil && e.Tags == nil {
+		e.Tags, err = tags.Load(tx, ID)
+	}
+
+	var after *history.State
+	if err == nil {
+		after, err = history.Load(tx, ID)
+	}
+	if err == nil {
+		err = history.Record(tx, ID, history.UpdateAction, actor, before, after)
+	}
+	if err == nil {
+		e.newRecords, err = records.Detect(tx, after.UserID, after.ExerciseType, ID)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
 
-	sqlStatement = `SELECT USER_ID, TYPE FROM exercises WHERE ID=$1;`
-	var userID int64
-	var exerciseType ExerciseType
+	e.UserID = after.UserID
+	e.ExerciseType = ExerciseType(after.ExerciseType)
+	e.Version = after.Version
+	e.Sets = after.Sets
+	e.Volume = sets.Volume(after.Sets)
+	e.Metrics = metrics.Calculate(after.ExerciseType, e.Duration, e.Distance)
+	if e.Circuit != nil {
+		e.Circuit.Expand()
+	}
 
-	_ = database.QueryRow(sqlStatement, ID).Scan(&userID, &exerciseType)
-	e.UserID = userID
-	e.ExerciseType = exerciseType
+	if e.Swim != nil {
+		e.Swim.Derive(e.Duration)
+	}
 
-	return err
+	return tx.Commit()
 }
 
 func response(w http.ResponseWriter, httpStatus int, response *Response, err error) {
@@ -199,6 +461,137 @@ func response(w http.ResponseWriter, httpStatus int, response *Response, err err
 	json.NewEncoder(w).Encode(response)
 }
 
+func saveExercise(w http.ResponseWriter, r *http.Request, exerciseID int64, exercise *Exercise) {
+	newResponse := &Response{}
+
+	stored, err := getStoredExercise(exerciseID)
+	if err == ErrNoExerciseFound {
+		response(w, http.StatusNotFound, newResponse, err)
+		return
+	}
+	if err != nil {
+		response(w, http.StatusInternalServerError, newResponse, err)
+		return
+	}
+
+	if !auth.FromRequest(r).CanActFor(stored.UserID) {
+		response(w, http.StatusForbidden, newResponse, auth.ErrForbidden)
+		return
+	}
+
+	if err = version.CheckIfMatch(r, exerciseID, stored.Version); err != nil {
+		response(w, version.Status(err), newResponse, err)
+		return
+	}
+
+	err = exercise.validateUpdateExerciseRequest(exerciseID)
+	if err != nil {
+		response(w, http.StatusBadRequest, newResponse, err)
+		return
+	}
+
+	if exercise.Timezone == "" {
+		exercise.Timezone = stored.Timezone
+	}
+
+	// calories not received are estimated for the stored type and user
+	exercise.CaloriesEstimated = false
+	if exercise.Calories == 0 {
+		err = exercise.estimateCalories(stored)
+		if err == calories.ErrNotEstimable {
+			response(w, http.StatusBadRequest, newResponse, err)
+			return
+		}
+		if err != nil {
+			response(w, http.StatusInternalServerError, newResponse, err)
+			return
+		}
+	}
+
+	// the stored swim must still match the distance when no new one is received
+	if exercise.Swim == nil {
+		exercise.Swim, err = getStoredSwim(exerciseID)
+		if err != nil {
+			response(w, http.StatusInternalServerError, newResponse, err)
+			return
+		}
+	}
+
+	if exercise.Swim != nil && exercise.Distance == 0 {
+		exercise.Distance = exercise.Swim.Distance()
+	}
+
+	// the type can not change, the distance is validated against the stored one
+	err = metrics.ValidateDistance(stored.ExerciseType, exercise.Duration, exercise.Distance)
+	if err != nil {
+		response(w, http.StatusBadRequest, newResponse, err)
+		return
+	}
+
+	err = swims.Validate(stored.ExerciseType, exercise.Distance, exercise.Swim)
+	if err != nil {
+		response(w, http.StatusBadRequest, newResponse, err)
+		return
+	}
+
+	// the stored circuit must still add up to the duration when no new one is received
+	if exercise.Circuit == nil {
+		exercise.Circuit, err = getStoredCircuit(exerciseID)
+		if err != nil {
+			response(w, http.StatusInternalServerError, newResponse, err)
+			return
+		}
+	}
+
+	err = circuits.Validate(stored.ExerciseType, exercise.Duration, exercise.Circuit)
+	if err != nil {
+		response(w, http.StatusBadRequest, newResponse, err)
+		return
+	}
+
+	err = sets.Validate(stored.ExerciseType, exercise.Sets)
+	if err != nil {
+		response(w, http.StatusBadRequest, newResponse, err)
+		return
+	}
+
+	// checked once the calories are estimated and the distance of a swim is known
+	err = exercise.checkPlausibility(exerciseID, stored)
+	if err == ErrDatabaseError {
+		response(w, http.StatusInternalServerError, newResponse, err)
+		return
+	}
+	if err != nil {
+		response(w, http.StatusBadRequest, newResponse, err)
+		return
+	}
+
+	err = checkArchived(exerciseID, exercise.StartTime)
+	if err == archive.ErrArchivedExercise {
+		response(w, http.StatusConflict, newResponse, err)
+		return
+	}
+	if err != nil {
+		response(w, http.StatusInternalServerError, newResponse, err)
+		return
+	}
+
+	err = exercise.updateExercise(exerciseID, stored.Version, auth.FromRequest(r).String())
+	if err == version.ErrPreconditionFailed {
+		response(w, http.StatusPreconditionFailed, newResponse, err)
+		return
+	}
+	if err != nil {
+		response(w, http.StatusInternalServerError, newResponse, err)
+		return
+	}
+
+	version.SetETag(w, exerciseID, exercise.Version)
+	newResponse.Exercise = exercise
+	newResponse.NewRecords = exercise.newRecords
+	response(w, http.StatusOK, newResponse, err)
+}
+
 // ExerciseEndpoint function that handles request and response
 func ExerciseEndpoint(w http.ResponseWriter, r *http.Request) {
 	exercise := &Exercise{}
@@ -218,18 +611,115 @@ func ExerciseEndpoint(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = exercise.validateUpdateExerciseRequest(exerciseID)
+	saveExercise(w, r, exerciseID, exercise)
+}
+
+// PatchEndpoint function that updates only the fields received
+func PatchEndpoint(w http.ResponseWriter, r *http.Request) {
+	patch := &Patch{}
+	newResponse := &Response{}
+	params := mux.Vars(r)
+
+	defer r.Body.Close()
+
+	if err := json.NewDecoder(r.Body).Decode(patch); err != nil {
+		response(w, http.StatusBadRequest, newResponse, err)
+		return
+	}
+
+	exerciseID, err := strconv.ParseInt(params["exerciseId"], 10, 64)
 	if err != nil {
 		response(w, http.StatusBadRequest, newResponse, err)
 		return
 	}
 
-	err = exercise.updateExercise(exerciseID)
+	if patch.UserID != nil {
+		response(w, http.StatusBadRequest, newResponse, ErrUnwantedUserID)
+		return
+	}
+
+	if patch.ExerciseType != nil {
+		response(w, http.StatusBadRequest, newResponse, ErrUnwantedType)
+		return
+	}
+
+	stored, err := getStoredExercise(exerciseID)
+	if err == ErrNoExerciseFound {
+		response(w, http.StatusNotFound, newResponse, err)
+		return
+	}
 	if err != nil {
 		response(w, http.StatusInternalServerError, newResponse, err)
 		return
 	}
 
-	newResponse.Exercise = exercise
-	response(w, http.StatusOK, newResponse, err)
+	exercise := &Exercise{
+		Description: stored.Description,
+		Notes:       stored.Notes,
+		StartTime:   stored.StartTime,
+		Timezone:    stored.Timezone,
+		Duration:    stored.Duration,
+		Calories:    stored.Calories,
+		Intensity:   calories.Intensity(stored.Intensity),
+		Distance:    stored.Distance,
+	}
+
+	// estimated calories follow the new duration and intensity unless calories are received
+	if stored.CaloriesEstimated {
+		exercise.Calories = 0
+	}
+
+	if patch.Description != nil {
+		exercise.Description = *patch.Description
+	}
+
+	if patch.Notes != nil {
+		exercise.Notes = *patch.Notes
+	}
+
+	if patch.Tags != nil {
+		exercise.Tags = *patch.Tags
+	}
+
+	if patch.StartTime != nil {
+		exercise.StartTime = *patch.StartTime
+	}
+
+	if patch.Timezone != nil {
+		exercise.Timezone = *patch.Timezone
+	}
+
+	if patch.Duration != nil {
+		exercise.Duration = *patch.Duration
+	}
+
+	if patch.Calories != nil {
+		exercise.Calories = *patch.Calories
+	}
+
+	if patch.Intensity != nil {
+		exercise.Intensity = *patch.Intensity
+	}
+
+	if patch.Distance != nil {
+		exercise.Distance = *patch.Distance
+	}
+
+	if patch.Sets != nil {
+		exercise.Sets = *patch.Sets
+	}
+
+	if patch.Circuit != nil {
+		exercise.Circuit = patch.Circuit
+	}
+
+	// new laps change the distance unless it is received too
+	if patch.Swim != nil {
+		exercise.Swim = patch.Swim
+		if patch.Distance == nil {
+			exercise.Distance = 0
+		}
+	}
+
+	saveExercise(w, r, exerciseID, exercise)
 }